@@ -4,12 +4,11 @@ go 1.23.4
 
 require (
 	github.com/containerd/cgroups/v3 v3.0.5
-	github.com/google/goterm v0.0.0-20200907032337-555d40f16ae2
+	github.com/docker/go-units v0.5.0
 	github.com/opencontainers/runtime-spec v1.2.0
 	github.com/sirupsen/logrus v1.9.3
 	github.com/spf13/cobra v1.8.1
 	github.com/syndtr/gocapability v0.0.0-20200815063812-42c35b437635
-	github.com/thediveo/gons v0.9.9
 	golang.org/x/sys v0.29.0
 )
 
@@ -17,7 +16,6 @@ require (
 	github.com/cilium/ebpf v0.16.0 // indirect
 	github.com/containerd/log v0.1.0 // indirect
 	github.com/coreos/go-systemd/v22 v22.5.0 // indirect
-	github.com/docker/go-units v0.5.0 // indirect
 	github.com/godbus/dbus/v5 v5.1.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/moby/sys/userns v0.1.0 // indirect
@@ -0,0 +1,39 @@
+package anosys
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func AppArmorEnabled() bool {
+	if _, err := os.Stat("/sys/kernel/security/apparmor"); err != nil {
+		return false
+	}
+
+	enabled, err := os.ReadFile("/sys/module/apparmor/parameters/enabled")
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(string(enabled), "Y")
+}
+
+// SetAppArmorProfile sets the profile the process transitions to on exec.
+// The attributes are per-thread, so the caller should be locked to its
+// thread.
+func SetAppArmorProfile(profile string) error {
+	value := []byte("exec " + profile)
+
+	err := os.WriteFile("/proc/thread-self/attr/apparmor/exec", value, 0644)
+	if errors.Is(err, os.ErrNotExist) {
+		// kernels < 5.8 don't have the apparmor specific attributes
+		err = os.WriteFile("/proc/thread-self/attr/exec", value, 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("write apparmor exec profile (%s): %w", profile, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,208 @@
+package anosys
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/containerd/cgroups/v3"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const cgroupMountsDir = "/sys/fs/cgroup"
+
+type cgroupMount struct {
+	root       string
+	mountpoint string
+	fstype     string
+	options    []string
+}
+
+// mountCgroups mounts the container's cgroups at the destination of m, as
+// cgroup2 when unified, otherwise as a tmpfs containing each hierarchy. The
+// mounts are read-only, unless rw is explicitly set.
+func mountCgroups(rootfs string, m specs.Mount) error {
+	options := slices.Clone(m.Options)
+	readOnly := !slices.Contains(options, "rw")
+	if readOnly {
+		options = append(options, "ro")
+	}
+
+	paths, unifiedPath, err := cgroups.ParseCgroupFileUnified(
+		"/proc/self/cgroup",
+	)
+	if err != nil {
+		return fmt.Errorf("parse cgroup file: %w", err)
+	}
+
+	if IsUnifiedCGroupsMode() {
+		return mountCgroupHierarchy(
+			rootfs,
+			m.Destination,
+			cgroupMount{root: "/", mountpoint: cgroupMountsDir, fstype: "cgroup2"},
+			unifiedPath,
+			nil,
+			options,
+		)
+	}
+
+	hierarchies, err := cgroupMounts()
+	if err != nil {
+		return err
+	}
+
+	tmpfsOptions := []string{"nosuid", "nodev", "noexec", "mode=755"}
+
+	if err := mountSpecMount(rootfs, specs.Mount{
+		Destination: m.Destination,
+		Type:        "tmpfs",
+		Source:      "tmpfs",
+		Options:     tmpfsOptions,
+	}, -1); err != nil {
+		return err
+	}
+
+	for _, h := range hierarchies {
+		name := filepath.Base(h.mountpoint)
+		dest := filepath.Join(m.Destination, name)
+
+		path := unifiedPath
+		var controllers []string
+
+		if h.fstype == "cgroup" {
+			for _, opt := range h.options {
+				if _, ok := paths[opt]; ok {
+					controllers = append(controllers, opt)
+				}
+			}
+
+			if len(controllers) == 0 {
+				continue
+			}
+
+			path = paths[controllers[0]]
+		}
+
+		if err := mountCgroupHierarchy(
+			rootfs,
+			dest,
+			h,
+			path,
+			controllers,
+			options,
+		); err != nil {
+			return err
+		}
+
+		// co-mounted controllers, e.g. cpu,cpuacct, are also linked by name
+		if strings.Contains(name, ",") {
+			for _, c := range strings.Split(name, ",") {
+				if err := createSymlinks(
+					map[string]string{name: filepath.Join(m.Destination, c)},
+					rootfs,
+				); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if !readOnly {
+		return nil
+	}
+
+	return mountSpecMount(rootfs, specs.Mount{
+		Destination: m.Destination,
+		Type:        "tmpfs",
+		Source:      "tmpfs",
+		Options:     append([]string{"remount", "ro"}, tmpfsOptions...),
+	}, -1)
+}
+
+func mountCgroupHierarchy(
+	rootfs, dest string,
+	h cgroupMount,
+	path string,
+	controllers []string,
+	options []string,
+) error {
+	// in a cgroup namespace, the container's cgroup is the root, so mounting
+	// the hierarchy only exposes the container's own cgroups
+	if path == "/" {
+		return mountSpecMount(rootfs, specs.Mount{
+			Destination: dest,
+			Type:        h.fstype,
+			Source:      h.fstype,
+			Options:     append(slices.Clone(options), controllers...),
+		}, -1)
+	}
+
+	// otherwise, bind only the container's cgroup, so the host's aren't
+	// visible
+	rel, err := filepath.Rel(h.root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf(
+			"cgroup (%s) is outside of mount root (%s)",
+			path,
+			h.root,
+		)
+	}
+
+	return mountSpecMount(rootfs, specs.Mount{
+		Destination: dest,
+		Type:        "bind",
+		Source:      filepath.Join(h.mountpoint, rel),
+		Options:     append(slices.Clone(options), "rbind"),
+	}, -1)
+}
+
+// cgroupMounts returns the cgroup hierarchies mounted in the cgroup mounts
+// dir.
+func cgroupMounts() ([]cgroupMount, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, fmt.Errorf("open mountinfo: %w", err)
+	}
+	defer f.Close()
+
+	var mounts []cgroupMount
+	seen := map[string]bool{}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// e.g. 33 32 0:29 / /sys/fs/cgroup/cpu rw,relatime - cgroup cgroup rw,cpu
+		fields := strings.Fields(scanner.Text())
+
+		sep := slices.Index(fields, "-")
+		if sep < 5 || len(fields) < sep+4 {
+			return nil, errors.New("parse mountinfo: invalid entry")
+		}
+
+		fstype := fields[sep+1]
+		if fstype != "cgroup" && fstype != "cgroup2" {
+			continue
+		}
+
+		mountpoint := fields[4]
+		if filepath.Dir(mountpoint) != cgroupMountsDir || seen[mountpoint] {
+			continue
+		}
+		seen[mountpoint] = true
+
+		mounts = append(mounts, cgroupMount{
+			root:       fields[3],
+			mountpoint: mountpoint,
+			fstype:     fstype,
+			options:    strings.Split(fields[sep+3], ","),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read mountinfo: %w", err)
+	}
+
+	return mounts, nil
+}
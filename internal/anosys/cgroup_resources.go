@@ -0,0 +1,401 @@
+package anosys
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+)
+
+type cgroupValue struct {
+	file  string
+	value string
+	// written instead when file doesn't exist, e.g. io.bfq.weight without bfq
+	fallback *cgroupValue
+}
+
+// controller is the controller the file belongs to, or "cgroup" for core
+// files.
+func (v cgroupValue) controller() string {
+	controller, _, _ := strings.Cut(v.file, ".")
+	return controller
+}
+
+// setV2Resources writes resources to the cgroup at dir, enabling any
+// controllers needed that aren't already available.
+func setV2Resources(dir string, resources *specs.LinuxResources) error {
+	values, err := v2ResourceValues(resources)
+	if err != nil {
+		return err
+	}
+
+	// unified values are written last, so they override the above
+	keys := make([]string, 0, len(resources.Unified))
+	for k := range resources.Unified {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		if k == "" || k == "." || k == ".." || strings.Contains(k, "/") {
+			return fmt.Errorf("invalid unified resource: %s", k)
+		}
+
+		values = append(values, cgroupValue{file: k, value: resources.Unified[k]})
+	}
+
+	var controllers []string
+	for _, v := range values {
+		if c := v.controller(); c != "cgroup" && !slices.Contains(controllers, c) {
+			controllers = append(controllers, c)
+		}
+	}
+
+	if err := enableV2Controllers(dir, controllers); err != nil {
+		return err
+	}
+
+	for _, v := range values {
+		err := writeCgroupFile(dir, v)
+		if errors.Is(err, os.ErrNotExist) && v.fallback != nil {
+			err = writeCgroupFile(dir, *v.fallback)
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("cgroup resource (%s) not supported", v.file)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeCgroupFile(dir string, v cgroupValue) error {
+	f, err := os.OpenFile(
+		filepath.Join(dir, v.file),
+		os.O_WRONLY|os.O_TRUNC,
+		0,
+	)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(v.value); err != nil {
+		return fmt.Errorf("write cgroup file (%s: %s): %w", v.file, v.value, err)
+	}
+
+	return nil
+}
+
+// enableV2Controllers checks controllers are available in the cgroup at dir,
+// and tries enabling any that aren't in the parent's subtree control.
+func enableV2Controllers(dir string, controllers []string) error {
+	available, err := availableV2Controllers(dir)
+	if err != nil {
+		return err
+	}
+
+	parent := filepath.Dir(dir)
+
+	for _, c := range controllers {
+		if slices.Contains(available, c) {
+			continue
+		}
+
+		if dir == cgroupMountsDir {
+			return fmt.Errorf("cgroup controller (%s) not available", c)
+		}
+
+		if err := os.WriteFile(
+			filepath.Join(parent, "cgroup.subtree_control"),
+			[]byte("+"+c),
+			0,
+		); err != nil {
+			return fmt.Errorf("cgroup controller (%s) not available: %w", c, err)
+		}
+	}
+
+	return nil
+}
+
+// V2CGroupControllerEnabled returns whether controller is available in the
+// root of the cgroup v2 hierarchy.
+func V2CGroupControllerEnabled(controller string) bool {
+	if !IsUnifiedCGroupsMode() {
+		return false
+	}
+
+	available, err := availableV2Controllers(cgroupMountsDir)
+	if err != nil {
+		return false
+	}
+
+	return slices.Contains(available, controller)
+}
+
+func availableV2Controllers(dir string) ([]string, error) {
+	b, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return nil, fmt.Errorf("read cgroup controllers: %w", err)
+	}
+
+	return strings.Fields(string(b)), nil
+}
+
+// v2ResourceValues converts resources to the cgroup v2 files and values to
+// write, following the conversions of runc and crun.
+func v2ResourceValues(resources *specs.LinuxResources) ([]cgroupValue, error) {
+	var values []cgroupValue
+
+	if cpu := resources.CPU; cpu != nil {
+		if cpu.RealtimeRuntime != nil || cpu.RealtimePeriod != nil {
+			return nil, errors.New(
+				"realtime cpu scheduling not supported by cgroup v2",
+			)
+		}
+
+		if cpu.Shares != nil && *cpu.Shares != 0 {
+			values = append(values, cgroupValue{
+				file:  "cpu.weight",
+				value: strconv.FormatUint(cpuSharesToWeight(*cpu.Shares), 10),
+			})
+		}
+
+		if cpu.Quota != nil || cpu.Period != nil {
+			quota := "max"
+			if cpu.Quota != nil && *cpu.Quota > 0 {
+				quota = strconv.FormatInt(*cpu.Quota, 10)
+			}
+
+			// period is optional, and kept as is if not set
+			max := quota
+			if cpu.Period != nil && *cpu.Period != 0 {
+				max += " " + strconv.FormatUint(*cpu.Period, 10)
+			}
+
+			values = append(values, cgroupValue{file: "cpu.max", value: max})
+		}
+
+		if cpu.Burst != nil {
+			values = append(values, cgroupValue{
+				file:  "cpu.max.burst",
+				value: strconv.FormatUint(*cpu.Burst, 10),
+			})
+		}
+
+		if cpu.Idle != nil {
+			values = append(values, cgroupValue{
+				file:  "cpu.idle",
+				value: strconv.FormatInt(*cpu.Idle, 10),
+			})
+		}
+
+		if cpu.Cpus != "" {
+			values = append(values, cgroupValue{file: "cpuset.cpus", value: cpu.Cpus})
+		}
+
+		if cpu.Mems != "" {
+			values = append(values, cgroupValue{file: "cpuset.mems", value: cpu.Mems})
+		}
+	}
+
+	if mem := resources.Memory; mem != nil {
+		if mem.Swappiness != nil || mem.DisableOOMKiller != nil {
+			logrus.Warn(
+				"memory swappiness and oom killer not supported by cgroup v2",
+			)
+		}
+
+		var limit, swap int64
+		if mem.Limit != nil {
+			limit = *mem.Limit
+		}
+		if mem.Swap != nil {
+			swap = *mem.Swap
+		}
+
+		if limit != 0 {
+			values = append(values, cgroupValue{
+				file:  "memory.max",
+				value: cgroupLimit(limit),
+			})
+		}
+
+		swapMax, ok, err := v2SwapMax(swap, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			values = append(values, cgroupValue{
+				file:  "memory.swap.max",
+				value: cgroupLimit(swapMax),
+			})
+		}
+
+		if mem.Reservation != nil && *mem.Reservation != 0 {
+			values = append(values, cgroupValue{
+				file:  "memory.low",
+				value: cgroupLimit(*mem.Reservation),
+			})
+		}
+	}
+
+	if pids := resources.Pids; pids != nil && pids.Limit != 0 {
+		values = append(values, cgroupValue{
+			file:  "pids.max",
+			value: cgroupLimit(pids.Limit),
+		})
+	}
+
+	if blkio := resources.BlockIO; blkio != nil {
+		if blkio.Weight != nil && *blkio.Weight != 0 {
+			values = append(values, ioWeightValue("", *blkio.Weight))
+		}
+
+		for _, d := range blkio.WeightDevice {
+			if d.Weight != nil && *d.Weight != 0 {
+				values = append(values, ioWeightValue(
+					fmt.Sprintf("%d:%d ", d.Major, d.Minor),
+					*d.Weight,
+				))
+			}
+		}
+
+		for _, t := range []struct {
+			key     string
+			devices []specs.LinuxThrottleDevice
+		}{
+			{"rbps", blkio.ThrottleReadBpsDevice},
+			{"wbps", blkio.ThrottleWriteBpsDevice},
+			{"riops", blkio.ThrottleReadIOPSDevice},
+			{"wiops", blkio.ThrottleWriteIOPSDevice},
+		} {
+			for _, d := range t.devices {
+				rate := "max"
+				if d.Rate != 0 {
+					rate = strconv.FormatUint(d.Rate, 10)
+				}
+
+				values = append(values, cgroupValue{
+					file:  "io.max",
+					value: fmt.Sprintf("%d:%d %s=%s", d.Major, d.Minor, t.key, rate),
+				})
+			}
+		}
+	}
+
+	for _, h := range resources.HugepageLimits {
+		values = append(values, cgroupValue{
+			file:  fmt.Sprintf("hugetlb.%s.max", h.Pagesize),
+			value: strconv.FormatUint(h.Limit, 10),
+		})
+	}
+
+	devices := make([]string, 0, len(resources.Rdma))
+	for d := range resources.Rdma {
+		devices = append(devices, d)
+	}
+	slices.Sort(devices)
+
+	for _, d := range devices {
+		limits := resources.Rdma[d]
+
+		value := d
+		if limits.HcaHandles != nil {
+			value += fmt.Sprintf(" hca_handle=%d", *limits.HcaHandles)
+		}
+		if limits.HcaObjects != nil {
+			value += fmt.Sprintf(" hca_object=%d", *limits.HcaObjects)
+		}
+
+		if value != d {
+			values = append(values, cgroupValue{file: "rdma.max", value: value})
+		}
+	}
+
+	if resources.Network != nil {
+		logrus.Warn("network resources not supported by cgroup v2")
+	}
+
+	return values, nil
+}
+
+// cpuSharesToWeight converts from [2-262144] to [1-10000].
+func cpuSharesToWeight(shares uint64) uint64 {
+	if shares < 2 {
+		shares = 2
+	}
+
+	if shares > 262144 {
+		shares = 262144
+	}
+
+	return 1 + ((shares-2)*9999)/262142
+}
+
+// ioWeightValue converts blkio weight, for the bfq scheduler's weight, or
+// io.weight if bfq isn't used, from [10-1000] to [1-10000].
+func ioWeightValue(device string, weight uint16) cgroupValue {
+	converted := 1 + (uint64(weight)-10)*9999/990
+	if weight < 10 {
+		converted = 1
+	}
+
+	return cgroupValue{
+		file:  "io.bfq.weight",
+		value: device + strconv.FormatUint(uint64(weight), 10),
+		fallback: &cgroupValue{
+			file:  "io.weight",
+			value: device + strconv.FormatUint(converted, 10),
+		},
+	}
+}
+
+// v2SwapMax converts the memory+swap limit in the spec to the swap only
+// limit in cgroup v2, and whether it's set. A limit of -1 is unlimited.
+func v2SwapMax(swap, limit int64) (int64, bool, error) {
+	// unlimited memory, without a swap limit, is unlimited swap too, as in v1
+	if limit == -1 && swap == 0 {
+		return -1, true, nil
+	}
+
+	if swap == 0 {
+		return 0, false, nil
+	}
+
+	if swap == -1 {
+		return -1, true, nil
+	}
+
+	if limit == 0 || limit == -1 {
+		return 0, false, errors.New("memory swap limit requires a memory limit")
+	}
+
+	if swap < limit {
+		return 0, false, fmt.Errorf(
+			"memory swap limit (%d) must be >= memory limit (%d)",
+			swap,
+			limit,
+		)
+	}
+
+	// equal limits disable swap
+	return swap - limit, true, nil
+}
+
+func cgroupLimit(limit int64) string {
+	if limit < 0 {
+		return "max"
+	}
+
+	return strconv.FormatInt(limit, 10)
+}
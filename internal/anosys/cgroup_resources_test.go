@@ -0,0 +1,391 @@
+package anosys
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestCPUSharesToWeight(t *testing.T) {
+	for _, tc := range []struct {
+		shares uint64
+		weight uint64
+	}{
+		{0, 1},
+		{2, 1},
+		{1024, 39},
+		{262144, 10000},
+		{300000, 10000},
+	} {
+		if got := cpuSharesToWeight(tc.shares); got != tc.weight {
+			t.Errorf("cpuSharesToWeight(%d) = %d, want %d", tc.shares, got, tc.weight)
+		}
+	}
+}
+
+func TestIOWeightValue(t *testing.T) {
+	for _, tc := range []struct {
+		device   string
+		weight   uint16
+		bfq      string
+		fallback string
+	}{
+		{"", 10, "10", "1"},
+		{"", 500, "500", "4950"},
+		{"", 1000, "1000", "10000"},
+		{"", 5, "5", "1"},
+		{"8:0 ", 100, "8:0 100", "8:0 910"},
+	} {
+		got := ioWeightValue(tc.device, tc.weight)
+
+		want := cgroupValue{
+			file:     "io.bfq.weight",
+			value:    tc.bfq,
+			fallback: &cgroupValue{file: "io.weight", value: tc.fallback},
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf(
+				"ioWeightValue(%q, %d) = %+v (fallback %+v), want %+v (fallback %+v)",
+				tc.device,
+				tc.weight,
+				got,
+				got.fallback,
+				want,
+				want.fallback,
+			)
+		}
+	}
+}
+
+func TestV2SwapMax(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		swap    int64
+		limit   int64
+		max     int64
+		set     bool
+		wantErr bool
+	}{
+		{name: "unset", swap: 0, limit: 0},
+		{name: "unset with limit", swap: 0, limit: 1024},
+		{name: "unlimited memory", swap: 0, limit: -1, max: -1, set: true},
+		{name: "unlimited swap", swap: -1, limit: 1024, max: -1, set: true},
+		{name: "swap limit", swap: 3072, limit: 1024, max: 2048, set: true},
+		{name: "no swap", swap: 1024, limit: 1024, max: 0, set: true},
+		{name: "less than limit", swap: 512, limit: 1024, wantErr: true},
+		{name: "without limit", swap: 1024, limit: 0, wantErr: true},
+		{name: "unlimited limit", swap: 1024, limit: -1, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			max, set, err := v2SwapMax(tc.swap, tc.limit)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tc.wantErr)
+			}
+
+			if max != tc.max || set != tc.set {
+				t.Errorf("= (%d, %t), want (%d, %t)", max, set, tc.max, tc.set)
+			}
+		})
+	}
+}
+
+func TestV2ResourceValues(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		resources *specs.LinuxResources
+		values    []cgroupValue
+		wantErr   bool
+	}{
+		{
+			name:      "empty",
+			resources: &specs.LinuxResources{},
+		},
+		{
+			name: "cpu",
+			resources: &specs.LinuxResources{
+				CPU: &specs.LinuxCPU{
+					Shares: ptr(uint64(1024)),
+					Quota:  ptr(int64(50000)),
+					Period: ptr(uint64(100000)),
+					Burst:  ptr(uint64(1000)),
+					Idle:   ptr(int64(1)),
+					Cpus:   "0-1",
+					Mems:   "0",
+				},
+			},
+			values: []cgroupValue{
+				{file: "cpu.weight", value: "39"},
+				{file: "cpu.max", value: "50000 100000"},
+				{file: "cpu.max.burst", value: "1000"},
+				{file: "cpu.idle", value: "1"},
+				{file: "cpuset.cpus", value: "0-1"},
+				{file: "cpuset.mems", value: "0"},
+			},
+		},
+		{
+			name: "cpu unlimited quota keeps period",
+			resources: &specs.LinuxResources{
+				CPU: &specs.LinuxCPU{Quota: ptr(int64(-1))},
+			},
+			values: []cgroupValue{{file: "cpu.max", value: "max"}},
+		},
+		{
+			name: "cpu realtime",
+			resources: &specs.LinuxResources{
+				CPU: &specs.LinuxCPU{RealtimeRuntime: ptr(int64(1000))},
+			},
+			wantErr: true,
+		},
+		{
+			name: "memory",
+			resources: &specs.LinuxResources{
+				Memory: &specs.LinuxMemory{
+					Limit:       ptr(int64(1024)),
+					Swap:        ptr(int64(1024)),
+					Reservation: ptr(int64(512)),
+				},
+			},
+			values: []cgroupValue{
+				{file: "memory.max", value: "1024"},
+				{file: "memory.swap.max", value: "0"},
+				{file: "memory.low", value: "512"},
+			},
+		},
+		{
+			name: "memory unlimited",
+			resources: &specs.LinuxResources{
+				Memory: &specs.LinuxMemory{Limit: ptr(int64(-1))},
+			},
+			values: []cgroupValue{
+				{file: "memory.max", value: "max"},
+				{file: "memory.swap.max", value: "max"},
+			},
+		},
+		{
+			name: "memory invalid swap",
+			resources: &specs.LinuxResources{
+				Memory: &specs.LinuxMemory{
+					Limit: ptr(int64(1024)),
+					Swap:  ptr(int64(512)),
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "pids",
+			resources: &specs.LinuxResources{
+				Pids: &specs.LinuxPids{Limit: -1},
+			},
+			values: []cgroupValue{{file: "pids.max", value: "max"}},
+		},
+		{
+			name: "block io",
+			resources: &specs.LinuxResources{
+				BlockIO: &specs.LinuxBlockIO{
+					ThrottleReadBpsDevice: []specs.LinuxThrottleDevice{
+						{
+							LinuxBlockIODevice: specs.LinuxBlockIODevice{
+								Major: 8,
+								Minor: 0,
+							},
+							Rate: 1048576,
+						},
+					},
+					ThrottleWriteIOPSDevice: []specs.LinuxThrottleDevice{
+						{
+							LinuxBlockIODevice: specs.LinuxBlockIODevice{
+								Major: 8,
+								Minor: 16,
+							},
+						},
+					},
+				},
+			},
+			values: []cgroupValue{
+				{file: "io.max", value: "8:0 rbps=1048576"},
+				{file: "io.max", value: "8:16 wiops=max"},
+			},
+		},
+		{
+			name: "hugetlb",
+			resources: &specs.LinuxResources{
+				HugepageLimits: []specs.LinuxHugepageLimit{
+					{Pagesize: "2MB", Limit: 4194304},
+				},
+			},
+			values: []cgroupValue{{file: "hugetlb.2MB.max", value: "4194304"}},
+		},
+		{
+			name: "rdma",
+			resources: &specs.LinuxResources{
+				Rdma: map[string]specs.LinuxRdma{
+					"mlx5_1": {HcaHandles: ptr(uint32(3))},
+					"mlx5_0": {
+						HcaHandles: ptr(uint32(2)),
+						HcaObjects: ptr(uint32(100)),
+					},
+					"mlx5_2": {},
+				},
+			},
+			values: []cgroupValue{
+				{file: "rdma.max", value: "mlx5_0 hca_handle=2 hca_object=100"},
+				{file: "rdma.max", value: "mlx5_1 hca_handle=3"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			values, err := v2ResourceValues(tc.resources)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tc.wantErr)
+			}
+
+			if !reflect.DeepEqual(values, tc.values) {
+				t.Errorf("values = %+v, want %+v", values, tc.values)
+			}
+		})
+	}
+}
+
+// fakeCgroup creates a cgroup dir in a temp dir, with the controllers
+// available and the files to write to.
+func fakeCgroup(t *testing.T, controllers string, files ...string) string {
+	t.Helper()
+
+	dir := filepath.Join(t.TempDir(), "container")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(
+		filepath.Join(dir, "cgroup.controllers"),
+		[]byte(controllers),
+		0644,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(dir, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func TestSetV2Resources(t *testing.T) {
+	dir := fakeCgroup(
+		t,
+		"cpu memory pids",
+		"cpu.weight",
+		"memory.max",
+		"memory.swap.max",
+		"memory.high",
+		"pids.max",
+	)
+
+	if err := setV2Resources(dir, &specs.LinuxResources{
+		CPU: &specs.LinuxCPU{Shares: ptr(uint64(2))},
+		Memory: &specs.LinuxMemory{
+			Limit: ptr(int64(1024)),
+			Swap:  ptr(int64(1024)),
+		},
+		Pids: &specs.LinuxPids{Limit: 10},
+		Unified: map[string]string{
+			"memory.high": "2048",
+			// overrides the above
+			"pids.max": "20",
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for file, want := range map[string]string{
+		"cpu.weight":      "1",
+		"memory.max":      "1024",
+		"memory.swap.max": "0",
+		"memory.high":     "2048",
+		"pids.max":        "20",
+	} {
+		if got := readTestFile(t, filepath.Join(dir, file)); got != want {
+			t.Errorf("%s = %q, want %q", file, got, want)
+		}
+	}
+}
+
+func TestSetV2ResourcesIOWeightFallback(t *testing.T) {
+	dir := fakeCgroup(t, "io", "io.weight")
+
+	if err := setV2Resources(dir, &specs.LinuxResources{
+		BlockIO: &specs.LinuxBlockIO{Weight: ptr(uint16(1000))},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readTestFile(t, filepath.Join(dir, "io.weight")); got != "10000" {
+		t.Errorf("io.weight = %q, want %q", got, "10000")
+	}
+}
+
+func TestSetV2ResourcesUnsupported(t *testing.T) {
+	dir := fakeCgroup(t, "memory")
+
+	err := setV2Resources(dir, &specs.LinuxResources{
+		Unified: map[string]string{"memory.high": "2048"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("err = %v, want not supported", err)
+	}
+}
+
+func TestSetV2ResourcesEnablesControllers(t *testing.T) {
+	dir := fakeCgroup(t, "", "pids.max")
+
+	if err := setV2Resources(dir, &specs.LinuxResources{
+		Pids: &specs.LinuxPids{Limit: 10},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readTestFile(
+		t,
+		filepath.Join(filepath.Dir(dir), "cgroup.subtree_control"),
+	)
+	if got != "+pids" {
+		t.Errorf("parent subtree control = %q, want %q", got, "+pids")
+	}
+}
+
+func TestSetV2ResourcesInvalidUnifiedKey(t *testing.T) {
+	for _, key := range []string{"", ".", "..", "../memory.max", "a/b"} {
+		t.Run(key, func(t *testing.T) {
+			dir := fakeCgroup(t, "memory", "memory.max")
+
+			if err := setV2Resources(dir, &specs.LinuxResources{
+				Unified: map[string]string{key: "1"},
+			}); err == nil {
+				t.Errorf("unified key %q: expected error", key)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package anosys
 
 import (
 	"fmt"
+	"path/filepath"
 
 	"github.com/containerd/cgroups/v3"
 	"github.com/containerd/cgroups/v3/cgroup1"
@@ -69,6 +70,22 @@ func AddV2CGroups(
 		return fmt.Errorf("add pid to cgroup2: %w", err)
 	}
 
+	// systemd only applies some resources as unit properties, so write them
+	// all to the cgroup
+	_, path, err := cgroups.ParseCgroupFileUnified(
+		fmt.Sprintf("/proc/%d/cgroup", pid),
+	)
+	if err != nil {
+		return fmt.Errorf("parse cgroup of pid (%d): %w", pid, err)
+	}
+
+	if err := setV2Resources(
+		filepath.Join(cgroupMountsDir, path),
+		resources,
+	); err != nil {
+		return fmt.Errorf("set cgroup resources (id: %s): %w", containerID, err)
+	}
+
 	return nil
 }
 
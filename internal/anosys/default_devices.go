@@ -1,13 +1,15 @@
 package anosys
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
+	"slices"
 	"syscall"
 
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
 )
 
@@ -22,6 +24,7 @@ var (
 var deviceType = map[string]uint32{
 	"b": unix.S_IFBLK,
 	"c": unix.S_IFCHR,
+	"u": unix.S_IFCHR,
 	"s": unix.S_IFSOCK,
 	"p": unix.S_IFIFO,
 }
@@ -89,55 +92,237 @@ var defaultDevices = []specs.LinuxDevice{
 	},
 }
 
-func MountDefaultDevices(rootfs string) error {
-	for _, d := range defaultDevices {
-		absPath := filepath.Join(rootfs, strings.TrimPrefix(d.Path, "/"))
+var defaultDevMounts = []specs.Mount{
+	{
+		Destination: "/dev/pts",
+		Type:        "devpts",
+		Source:      "devpts",
+		Options: []string{
+			"nosuid",
+			"noexec",
+			"newinstance",
+			"ptmxmode=0666",
+			"mode=0620",
+		},
+	},
+	{
+		Destination: "/dev/shm",
+		Type:        "tmpfs",
+		Source:      "shm",
+		Options: []string{
+			"nosuid",
+			"noexec",
+			"nodev",
+			"mode=1777",
+			"size=65536k",
+		},
+	},
+	{
+		Destination: "/dev/mqueue",
+		Type:        "mqueue",
+		Source:      "mqueue",
+		Options:     []string{"nosuid", "noexec", "nodev"},
+	},
+}
 
-		f, err := os.Create(absPath)
-		if err != nil && !os.IsExist(err) {
-			return err
+// MountDefaultDevMounts mounts the filesystems expected in /dev, unless the
+// spec already mounts something at the same destination, with the selinux
+// label, if set.
+func MountDefaultDevMounts(
+	rootfs string,
+	mounts []specs.Mount,
+	label string,
+) error {
+	defaults := defaultDevMounts
+	if label != "" {
+		defaults = LabelMounts(defaults, label)
+	}
+
+	for _, d := range defaults {
+		if slices.ContainsFunc(mounts, func(m specs.Mount) bool {
+			return filepath.Clean(m.Destination) == d.Destination
+		}) {
+			continue
 		}
-		f.Close()
-
-		if err := syscall.Mount(
-			d.Path,
-			absPath,
-			"bind",
-			unix.MS_BIND,
-			"",
-		); err != nil {
-			return fmt.Errorf("bind mount device: %w", err)
+
+		err := mountSpecMount(rootfs, d, -1)
+		// mqueue can only be mounted by the owner of the ipc namespace
+		if d.Type == "mqueue" && errors.Is(err, unix.EPERM) {
+			logrus.Debugf("skipping default mount (%s): %s", d.Destination, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("mount default (%s): %w", d.Destination, err)
 		}
 	}
 
 	return nil
 }
 
+func MountDefaultDevices(rootfs string) error {
+	return CreateDeviceNodes(defaultDevices, rootfs)
+}
+
 func CreateDeviceNodes(devices []specs.LinuxDevice, rootfs string) error {
 	for _, d := range devices {
-		absPath := filepath.Join(rootfs, strings.TrimPrefix(d.Path, "/"))
+		dir, err := MkdirAllInRoot(rootfs, filepath.Dir(d.Path), 0755)
+		if err != nil {
+			return fmt.Errorf("create device dir: %w", err)
+		}
 
-		if err := unix.Mknod(
-			absPath,
-			deviceType[d.Type],
-			int(unix.Mkdev(uint32(d.Major), uint32(d.Minor))),
-		); err != nil {
-			return err
+		name := filepath.Base(d.Path)
+
+		// e.g. a default device already bind mounted, which can't be
+		// replaced
+		mounted, err := isMountPoint(int(dir.Fd()), name)
+		if err != nil {
+			dir.Close()
+			return fmt.Errorf("check device node (%s): %w", d.Path, err)
+		}
+		if mounted {
+			dir.Close()
+			logrus.Debugf("skipping device (%s), already mounted", d.Path)
+			continue
 		}
 
-		if err := syscall.Chmod(absPath, uint32(*d.FileMode)); err != nil {
-			return err
+		err = createDeviceNode(int(dir.Fd()), name, d)
+		if errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES) {
+			// mknod isn't permitted, e.g. in a user namespace, so bind mount
+			// the host's device instead
+			err = bindDeviceNode(int(dir.Fd()), name, d)
+		}
+		dir.Close()
+		if err != nil {
+			return fmt.Errorf("create device node (%s): %w", d.Path, err)
 		}
+	}
+
+	return nil
+}
 
-		if d.UID != nil && d.GID != nil {
-			if err := os.Chown(
-				absPath,
-				int(*d.UID),
-				int(*d.GID),
-			); err != nil {
-				return err
-			}
+// isMountPoint returns whether name in dirFd is the root of a mount.
+func isMountPoint(dirFd int, name string) (bool, error) {
+	var stx unix.Statx_t
+	if err := unix.Statx(
+		dirFd,
+		name,
+		unix.AT_SYMLINK_NOFOLLOW,
+		0,
+		&stx,
+	); err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return false, nil
 		}
+		return false, err
+	}
+
+	if stx.Attributes_mask&unix.STATX_ATTR_MOUNT_ROOT != 0 {
+		return stx.Attributes&unix.STATX_ATTR_MOUNT_ROOT != 0, nil
+	}
+
+	// kernels < 5.8 don't report the mount root, so compare devices with
+	// the parent instead, which misses binds from the same filesystem
+	var dirStx unix.Statx_t
+	if err := unix.Statx(dirFd, "", unix.AT_EMPTY_PATH, 0, &dirStx); err != nil {
+		return false, err
+	}
+
+	return stx.Dev_major != dirStx.Dev_major ||
+		stx.Dev_minor != dirStx.Dev_minor, nil
+}
+
+func createDeviceNode(dirFd int, name string, d specs.LinuxDevice) error {
+	fileType, ok := deviceType[d.Type]
+	if !ok {
+		return fmt.Errorf("unsupported device type: %s", d.Type)
+	}
+
+	mode := defaultFileMode
+	if d.FileMode != nil {
+		mode = *d.FileMode
+	}
+
+	// replace anything already in the rootfs
+	if err := unix.Unlinkat(dirFd, name, 0); err != nil &&
+		!errors.Is(err, unix.ENOENT) {
+		return err
+	}
+
+	if err := unix.Mknodat(
+		dirFd,
+		name,
+		fileType|uint32(mode.Perm()),
+		int(unix.Mkdev(uint32(d.Major), uint32(d.Minor))),
+	); err != nil {
+		return err
+	}
+
+	// mknod is subject to the umask
+	if err := unix.Fchmodat(dirFd, name, uint32(mode.Perm()), 0); err != nil {
+		return err
+	}
+
+	uid, gid := -1, -1
+	if d.UID != nil {
+		uid = int(*d.UID)
+	}
+	if d.GID != nil {
+		gid = int(*d.GID)
+	}
+
+	if uid != -1 || gid != -1 {
+		if err := unix.Fchownat(
+			dirFd,
+			name,
+			uid,
+			gid,
+			unix.AT_SYMLINK_NOFOLLOW,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bindDeviceNode bind mounts the host's device at the same path. The mode
+// and ownership are the host's, since changing them would change the host's.
+func bindDeviceNode(dirFd int, name string, d specs.LinuxDevice) error {
+	if err := unix.Unlinkat(dirFd, name, 0); err != nil &&
+		!errors.Is(err, unix.ENOENT) {
+		return err
+	}
+
+	fd, err := unix.Openat(
+		dirFd,
+		name,
+		unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_CLOEXEC,
+		0666,
+	)
+	if err != nil {
+		return fmt.Errorf("create device target: %w", err)
+	}
+	unix.Close(fd)
+
+	target, err := unix.Openat(
+		dirFd,
+		name,
+		unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC,
+		0,
+	)
+	if err != nil {
+		return fmt.Errorf("open device target: %w", err)
+	}
+	defer unix.Close(target)
+
+	if err := syscall.Mount(
+		d.Path,
+		fmt.Sprintf("/proc/self/fd/%d", target),
+		"bind",
+		unix.MS_BIND,
+		"",
+	); err != nil {
+		return fmt.Errorf("bind mount device: %w", err)
 	}
 
 	return nil
@@ -0,0 +1,122 @@
+package anosys
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/containerd/cgroups/v3"
+	"github.com/containerd/cgroups/v3/cgroup2"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// devices needed for terminals in the container, i.e. /dev/console,
+// /dev/ptmx and /dev/pts/*
+var terminalDeviceRules = []specs.LinuxDeviceCgroup{
+	allowDevice(CharDevice, 5, 1),
+	allowDevice(CharDevice, 5, 2),
+	allowDevice(CharDevice, 136, -1),
+}
+
+// SetV2DeviceRules attaches a BPF_CGROUP_DEVICE program enforcing rules to
+// the cgroup of pid. The default devices, terminals and devices in the spec
+// are always allowed, since the container can't work without them.
+func SetV2DeviceRules(
+	pid int,
+	rules []specs.LinuxDeviceCgroup,
+	devices []specs.LinuxDevice,
+) error {
+	// devices aren't controlled
+	if len(rules) == 0 {
+		return nil
+	}
+
+	_, path, err := cgroups.ParseCgroupFileUnified(
+		fmt.Sprintf("/proc/%d/cgroup", pid),
+	)
+	if err != nil {
+		return fmt.Errorf("parse cgroup of pid (%d): %w", pid, err)
+	}
+
+	var allRules []specs.LinuxDeviceCgroup
+	for _, r := range rules {
+		allRules = append(allRules, normaliseDeviceRule(r))
+	}
+
+	// later rules take precedence
+	allRules = append(allRules, terminalDeviceRules...)
+	for _, d := range slices.Concat(defaultDevices, devices) {
+		switch d.Type {
+		case CharDevice, UnbufferedCharDevice:
+			allRules = append(allRules, allowDevice(CharDevice, d.Major, d.Minor))
+		case BlockDevice:
+			allRules = append(allRules, allowDevice(BlockDevice, d.Major, d.Minor))
+		}
+	}
+
+	insts, license, err := cgroup2.DeviceFilter(allRules)
+	if err != nil {
+		return fmt.Errorf("generate device filter: %w", err)
+	}
+
+	dir := filepath.Join(cgroupMountsDir, path)
+
+	dirFd, err := unix.Open(
+		dir,
+		unix.O_DIRECTORY|unix.O_RDONLY|unix.O_CLOEXEC,
+		0,
+	)
+	if err != nil {
+		return fmt.Errorf("open cgroup (%s): %w", dir, err)
+	}
+	defer unix.Close(dirFd)
+
+	if _, err := cgroup2.LoadAttachCgroupDeviceFilter(
+		insts,
+		license,
+		dirFd,
+	); err != nil {
+		return fmt.Errorf("attach device filter (%s): %w", dir, err)
+	}
+
+	return nil
+}
+
+// normaliseDeviceRule fills in the fields the spec allows to be omitted.
+func normaliseDeviceRule(r specs.LinuxDeviceCgroup) specs.LinuxDeviceCgroup {
+	wildcard := int64(-1)
+
+	if r.Type == "" {
+		r.Type = AllDevices
+	}
+
+	if r.Major == nil {
+		r.Major = &wildcard
+	}
+
+	if r.Minor == nil {
+		r.Minor = &wildcard
+	}
+
+	if r.Access == "" {
+		r.Access = "rwm"
+	}
+
+	return r
+}
+
+// allowDevice returns a rule allowing all access to the device, where -1 is
+// a wildcard.
+func allowDevice(
+	deviceType string,
+	major, minor int64,
+) specs.LinuxDeviceCgroup {
+	return specs.LinuxDeviceCgroup{
+		Allow:  true,
+		Type:   deviceType,
+		Major:  &major,
+		Minor:  &minor,
+		Access: "rwm",
+	}
+}
@@ -0,0 +1,169 @@
+package anosys
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"syscall"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+func IsIDMappedMount(m specs.Mount) bool {
+	return len(m.UIDMappings) > 0 ||
+		len(m.GIDMappings) > 0 ||
+		slices.Contains(m.Options, "idmap") ||
+		slices.Contains(m.Options, "ridmap")
+}
+
+// PrepareIDMappedMount creates a detached, idmapped clone of the mount source
+// and returns a (not close-on-exec) fd for it, to be attached at the
+// destination from inside the container's mount namespace.
+func PrepareIDMappedMount(m specs.Mount) (int, error) {
+	isBind := m.Type == "bind" ||
+		slices.Contains(m.Options, "bind") ||
+		slices.Contains(m.Options, "rbind")
+	if !isBind {
+		return -1, fmt.Errorf("idmap only supported for bind mounts (%s)", m.Destination)
+	}
+
+	// mounts without their own mappings are given the container's, so there
+	// are only none if the container has no user namespace to map from
+	if len(m.UIDMappings) == 0 || len(m.GIDMappings) == 0 {
+		return -1, fmt.Errorf(
+			"idmap mount requires uid and gid mappings or a user namespace (%s)",
+			m.Destination,
+		)
+	}
+
+	var treeFlags uint = unix.OPEN_TREE_CLONE
+	var attrFlags uint = unix.AT_EMPTY_PATH
+	if slices.Contains(m.Options, "rbind") {
+		treeFlags |= unix.AT_RECURSIVE
+	}
+	if slices.Contains(m.Options, "ridmap") {
+		attrFlags |= unix.AT_RECURSIVE
+	}
+
+	fd, err := unix.OpenTree(unix.AT_FDCWD, m.Source, treeFlags)
+	if err != nil {
+		return -1, fmt.Errorf("open tree (%s): %w", m.Source, err)
+	}
+
+	usernsFd, err := userNamespaceFd(m.UIDMappings, m.GIDMappings)
+	if err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	defer unix.Close(usernsFd)
+
+	set, clr := parseMountOptions(m.Options).mountAttrs()
+
+	attr := &unix.MountAttr{
+		Attr_set:  set | unix.MOUNT_ATTR_IDMAP,
+		Attr_clr:  clr,
+		Userns_fd: uint64(usernsFd),
+	}
+
+	if err := unix.MountSetattr(fd, "", attrFlags, attr); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("set idmap mount attr (%s): %w", m.Source, err)
+	}
+
+	return fd, nil
+}
+
+// IDMappedMountsSupported probes whether the kernel supports idmapped
+// mounts. The idmap attr is validated before the mount, so an fd that's
+// invalid fails with EBADF, or with EPERM for the initial user namespace,
+// rather than ENOSYS or EINVAL when it's unsupported.
+func IDMappedMountsSupported() bool {
+	usernsFd, err := unix.Open(
+		"/proc/self/ns/user",
+		unix.O_RDONLY|unix.O_CLOEXEC,
+		0,
+	)
+	if err != nil {
+		return false
+	}
+	defer unix.Close(usernsFd)
+
+	err = unix.MountSetattr(-1, "", unix.AT_EMPTY_PATH, &unix.MountAttr{
+		Attr_set:  unix.MOUNT_ATTR_IDMAP,
+		Userns_fd: uint64(usernsFd),
+	})
+
+	return errors.Is(err, unix.EPERM) || errors.Is(err, unix.EBADF)
+}
+
+func AttachMount(fd int, dest string) error {
+	if err := unix.MoveMount(
+		fd,
+		"",
+		unix.AT_FDCWD,
+		dest,
+		unix.MOVE_MOUNT_F_EMPTY_PATH|unix.MOVE_MOUNT_T_SYMLINKS,
+	); err != nil {
+		return fmt.Errorf("move mount to %s: %w", dest, err)
+	}
+
+	return unix.Close(fd)
+}
+
+// userNamespaceFd returns an fd for a new user namespace with the given
+// mappings. The process holding the namespace is stopped by ptrace on exec,
+// then killed once the namespace fd is open.
+func userNamespaceFd(
+	uidMappings []specs.LinuxIDMapping,
+	gidMappings []specs.LinuxIDMapping,
+) (int, error) {
+	// the tracer is the thread that starts the process, so it must stay the
+	// same thread until the process is killed and waited on
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	proc, err := os.StartProcess("/proc/self/exe", []string{"anocir"}, &os.ProcAttr{
+		Sys: &syscall.SysProcAttr{
+			Cloneflags:  unix.CLONE_NEWUSER,
+			UidMappings: toSysProcIDMaps(uidMappings),
+			GidMappings: toSysProcIDMaps(gidMappings),
+			Ptrace:      true,
+			Pdeathsig:   unix.SIGKILL,
+		},
+	})
+	if err != nil {
+		return -1, fmt.Errorf("start user namespace process: %w", err)
+	}
+	defer func() {
+		proc.Kill()
+		proc.Wait()
+	}()
+
+	fd, err := unix.Open(
+		fmt.Sprintf("/proc/%d/ns/user", proc.Pid),
+		unix.O_RDONLY|unix.O_CLOEXEC,
+		0,
+	)
+	if err != nil {
+		return -1, fmt.Errorf("open user namespace: %w", err)
+	}
+
+	return fd, nil
+}
+
+func toSysProcIDMaps(mappings []specs.LinuxIDMapping) []syscall.SysProcIDMap {
+	m := make([]syscall.SysProcIDMap, len(mappings))
+
+	for i, mapping := range mappings {
+		m[i] = syscall.SysProcIDMap{
+			ContainerID: int(mapping.ContainerID),
+			HostID:      int(mapping.HostID),
+			Size:        int(mapping.Size),
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,221 @@
+package anosys
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const landlockRuleNetPort = 2
+
+// access rights handled by each landlock abi version
+var landlockFSAccess = map[int]uint64{
+	1: unix.LANDLOCK_ACCESS_FS_EXECUTE |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_FIFO |
+		unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM,
+	2: unix.LANDLOCK_ACCESS_FS_REFER,
+	3: unix.LANDLOCK_ACCESS_FS_TRUNCATE,
+	5: unix.LANDLOCK_ACCESS_FS_IOCTL_DEV,
+}
+
+const (
+	landlockReadAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR
+
+	// the only rights that apply to files, rather than directories
+	landlockFileAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_TRUNCATE |
+		unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+)
+
+type LandlockRules struct {
+	// nil doesn't restrict filesystem access, unless the other is set,
+	// whereas empty denies it all
+	ReadOnlyPaths  []string
+	ReadWritePaths []string
+	// nil doesn't restrict network access, whereas empty denies it all
+	BindPorts    []uint16
+	ConnectPorts []uint16
+}
+
+type landlockNetPortAttr struct {
+	allowedAccess uint64
+	port          uint64
+}
+
+// ApplyLandlock restricts the filesystem and network access of the process,
+// and any it execs, to that allowed by rules. The process must have no new
+// privileges set.
+func ApplyLandlock(rules *LandlockRules) error {
+	abi, _, errno := unix.Syscall(
+		unix.SYS_LANDLOCK_CREATE_RULESET,
+		0,
+		0,
+		unix.LANDLOCK_CREATE_RULESET_VERSION,
+	)
+	if errno != 0 {
+		if errors.Is(errno, unix.ENOSYS) || errors.Is(errno, unix.EOPNOTSUPP) {
+			return errors.New("landlock is not supported or enabled by the kernel")
+		}
+		return fmt.Errorf("get landlock abi version: %w", errno)
+	}
+
+	// with only network rules, handling filesystem access would deny it all
+	var handledFS uint64
+	if rules.ReadOnlyPaths != nil || rules.ReadWritePaths != nil {
+		for version, access := range landlockFSAccess {
+			if int(abi) >= version {
+				handledFS |= access
+			}
+		}
+	}
+
+	attr := unix.LandlockRulesetAttr{Access_fs: handledFS}
+
+	if rules.BindPorts != nil || rules.ConnectPorts != nil {
+		if abi < 4 {
+			return fmt.Errorf(
+				"landlock network rules require abi version 4, but kernel has %d",
+				abi,
+			)
+		}
+
+		if rules.BindPorts != nil {
+			attr.Access_net |= unix.LANDLOCK_ACCESS_NET_BIND_TCP
+		}
+
+		if rules.ConnectPorts != nil {
+			attr.Access_net |= unix.LANDLOCK_ACCESS_NET_CONNECT_TCP
+		}
+	}
+
+	fd, _, errno := unix.Syscall(
+		unix.SYS_LANDLOCK_CREATE_RULESET,
+		uintptr(unsafe.Pointer(&attr)),
+		unsafe.Sizeof(attr),
+		0,
+	)
+	if errno != 0 {
+		return fmt.Errorf("create landlock ruleset: %w", errno)
+	}
+	defer unix.Close(int(fd))
+
+	for _, p := range rules.ReadOnlyPaths {
+		if err := addLandlockPathRule(
+			int(fd),
+			p,
+			landlockReadAccess&handledFS,
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range rules.ReadWritePaths {
+		if err := addLandlockPathRule(int(fd), p, handledFS); err != nil {
+			return err
+		}
+	}
+
+	for _, port := range rules.BindPorts {
+		if err := addLandlockPortRule(
+			int(fd),
+			port,
+			unix.LANDLOCK_ACCESS_NET_BIND_TCP,
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, port := range rules.ConnectPorts {
+		if err := addLandlockPortRule(
+			int(fd),
+			port,
+			unix.LANDLOCK_ACCESS_NET_CONNECT_TCP,
+		); err != nil {
+			return err
+		}
+	}
+
+	if _, _, errno := unix.Syscall(
+		unix.SYS_LANDLOCK_RESTRICT_SELF,
+		fd,
+		0,
+		0,
+	); errno != 0 {
+		return fmt.Errorf("restrict self with landlock ruleset: %w", errno)
+	}
+
+	return nil
+}
+
+func addLandlockPathRule(rulesetFd int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("open landlock path (%s): %w", path, err)
+	}
+	defer unix.Close(fd)
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("stat landlock path (%s): %w", path, err)
+	}
+
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= landlockFileAccess
+	}
+
+	attr := unix.LandlockPathBeneathAttr{
+		Allowed_access: access,
+		Parent_fd:      int32(fd),
+	}
+
+	if _, _, errno := unix.Syscall6(
+		unix.SYS_LANDLOCK_ADD_RULE,
+		uintptr(rulesetFd),
+		unix.LANDLOCK_RULE_PATH_BENEATH,
+		uintptr(unsafe.Pointer(&attr)),
+		0,
+		0,
+		0,
+	); errno != 0 {
+		return fmt.Errorf("add landlock path rule (%s): %w", path, errno)
+	}
+
+	return nil
+}
+
+func addLandlockPortRule(rulesetFd int, port uint16, access uint64) error {
+	attr := landlockNetPortAttr{
+		allowedAccess: access,
+		port:          uint64(port),
+	}
+
+	if _, _, errno := unix.Syscall6(
+		unix.SYS_LANDLOCK_ADD_RULE,
+		uintptr(rulesetFd),
+		landlockRuleNetPort,
+		uintptr(unsafe.Pointer(&attr)),
+		0,
+		0,
+		0,
+	); errno != 0 {
+		return fmt.Errorf("add landlock port rule (%d): %w", port, errno)
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package anosys
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func SetupLoopback() error {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		return fmt.Errorf("find loopback interface: %w", err)
+	}
+
+	if lo.Flags&net.FlagUp != 0 {
+		return nil
+	}
+
+	fd, err := unix.Socket(
+		unix.AF_NETLINK,
+		unix.SOCK_RAW|unix.SOCK_CLOEXEC,
+		unix.NETLINK_ROUTE,
+	)
+	if err != nil {
+		return fmt.Errorf("create netlink socket: %w", err)
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Sendto(
+		fd,
+		linkUpRequest(lo.Index),
+		0,
+		&unix.SockaddrNetlink{Family: unix.AF_NETLINK},
+	); err != nil {
+		return fmt.Errorf("send netlink link up request: %w", err)
+	}
+
+	b := make([]byte, unix.Getpagesize())
+	n, _, err := unix.Recvfrom(fd, b, 0)
+	if err != nil {
+		return fmt.Errorf("receive netlink response: %w", err)
+	}
+
+	msgs, err := syscall.ParseNetlinkMessage(b[:n])
+	if err != nil {
+		return fmt.Errorf("parse netlink response: %w", err)
+	}
+
+	for _, m := range msgs {
+		if m.Header.Type != unix.NLMSG_ERROR {
+			continue
+		}
+
+		if len(m.Data) < 4 {
+			return fmt.Errorf("short netlink error message")
+		}
+
+		// an error code of 0 is an ack
+		if errno := int32(binary.NativeEndian.Uint32(m.Data[:4])); errno != 0 {
+			return fmt.Errorf("set loopback up: %w", unix.Errno(-errno))
+		}
+	}
+
+	return nil
+}
+
+func linkUpRequest(index int) []byte {
+	b := make([]byte, unix.NLMSG_HDRLEN+unix.SizeofIfInfomsg)
+
+	// nlmsghdr
+	binary.NativeEndian.PutUint32(b[0:4], uint32(len(b)))
+	binary.NativeEndian.PutUint16(b[4:6], unix.RTM_NEWLINK)
+	binary.NativeEndian.PutUint16(b[6:8], unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	binary.NativeEndian.PutUint32(b[8:12], 1)
+	binary.NativeEndian.PutUint32(b[12:16], 0)
+
+	// ifinfomsg
+	ifi := b[unix.NLMSG_HDRLEN:]
+	ifi[0] = unix.AF_UNSPEC
+	binary.NativeEndian.PutUint32(ifi[4:8], uint32(index))
+	binary.NativeEndian.PutUint32(ifi[8:12], unix.IFF_UP)
+	binary.NativeEndian.PutUint32(ifi[12:16], unix.IFF_UP)
+
+	return b
+}
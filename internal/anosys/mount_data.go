@@ -0,0 +1,226 @@
+package anosys
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type dataKind int
+
+const (
+	dataFlag dataKind = iota
+	dataString
+	dataUint
+	dataMode
+	dataSize
+)
+
+type dataOption struct {
+	kind dataKind
+	// allowed values, if restricted
+	values []string
+}
+
+// filesystemData are the data options understood by each filesystem type.
+// Types not listed are passed through to the kernel unchecked.
+var filesystemData = map[string]map[string]dataOption{
+	"tmpfs": {
+		"size":      {kind: dataSize},
+		"nr_blocks": {kind: dataSize},
+		"nr_inodes": {kind: dataSize},
+		"mode":      {kind: dataMode},
+		"uid":       {kind: dataUint},
+		"gid":       {kind: dataUint},
+		"mpol":      {kind: dataString},
+		"huge": {
+			kind:   dataString,
+			values: []string{"never", "always", "within_size", "advise"},
+		},
+		"inode32": {kind: dataFlag},
+		"inode64": {kind: dataFlag},
+		"noswap":  {kind: dataFlag},
+	},
+	"overlay": {
+		"lowerdir":            {kind: dataString},
+		"lowerdir+":           {kind: dataString},
+		"upperdir":            {kind: dataString},
+		"workdir":             {kind: dataString},
+		"datadir+":            {kind: dataString},
+		"default_permissions": {kind: dataFlag},
+		"redirect_dir": {
+			kind:   dataString,
+			values: []string{"on", "off", "follow", "nofollow"},
+		},
+		"index":      {kind: dataString, values: []string{"on", "off"}},
+		"nfs_export": {kind: dataString, values: []string{"on", "off"}},
+		"xino":       {kind: dataString, values: []string{"on", "off", "auto"}},
+		"metacopy":   {kind: dataString, values: []string{"on", "off"}},
+		"uuid": {
+			kind:   dataString,
+			values: []string{"on", "off", "null", "auto"},
+		},
+		"verity": {
+			kind:   dataString,
+			values: []string{"on", "off", "require"},
+		},
+		"volatile":  {kind: dataFlag},
+		"userxattr": {kind: dataFlag},
+	},
+	"devpts": {
+		"newinstance": {kind: dataFlag},
+		"uid":         {kind: dataUint},
+		"gid":         {kind: dataUint},
+		"mode":        {kind: dataMode},
+		"ptmxmode":    {kind: dataMode},
+		"max":         {kind: dataUint},
+	},
+	"proc": {
+		"hidepid": {
+			kind: dataString,
+			values: []string{
+				"0", "1", "2", "4",
+				"off", "noaccess", "invisible", "ptraceable",
+			},
+		},
+		"subset": {kind: dataString, values: []string{"pid"}},
+		"gid":    {kind: dataUint},
+	},
+	"mqueue": {},
+	"sysfs":  {},
+	"cgroup2": {
+		"nsdelegate":                {kind: dataFlag},
+		"favordynmods":              {kind: dataFlag},
+		"memory_localevents":        {kind: dataFlag},
+		"memory_recursiveprot":      {kind: dataFlag},
+		"memory_hugetlb_accounting": {kind: dataFlag},
+		"pids_localevents":          {kind: dataFlag},
+	},
+}
+
+// security options, understood by all filesystems when an LSM is enabled
+var lsmMountData = []string{
+	"context",
+	"fscontext",
+	"defcontext",
+	"rootcontext",
+}
+
+var sizeValue = regexp.MustCompile(`^[0-9]+([kKmMgGtTpPeE%])?$`)
+
+type mountData struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+func (d mountData) String() string {
+	// e.g. selinux labels with categories, like s0:c1,c2
+	if d.hasValue && strings.Contains(d.value, ",") {
+		return d.key + "=\"" + d.value + "\""
+	}
+
+	if d.hasValue {
+		return d.key + "=" + d.value
+	}
+
+	return d.key
+}
+
+func parseMountData(opt string) mountData {
+	key, value, ok := strings.Cut(opt, "=")
+
+	return mountData{key: key, value: value, hasValue: ok}
+}
+
+// validateMountData checks the data options are understood by the
+// filesystem type, and their values are well formed.
+func validateMountData(fstype string, data []mountData) error {
+	known, ok := filesystemData[fstype]
+	if !ok {
+		return nil
+	}
+
+	for _, d := range data {
+		if slices.Contains(lsmMountData, d.key) {
+			continue
+		}
+
+		opt, ok := known[d.key]
+		if !ok {
+			return fmt.Errorf("unknown %s option: %s", fstype, d.key)
+		}
+
+		if err := opt.validate(d); err != nil {
+			return fmt.Errorf("invalid %s option (%s): %w", fstype, d, err)
+		}
+	}
+
+	if fstype == "overlay" {
+		return validateOverlayData(data)
+	}
+
+	return nil
+}
+
+func (o dataOption) validate(d mountData) error {
+	if o.kind == dataFlag {
+		if d.hasValue {
+			return errors.New("doesn't take a value")
+		}
+		return nil
+	}
+
+	if !d.hasValue || d.value == "" {
+		return errors.New("requires a value")
+	}
+
+	if len(o.values) > 0 && !slices.Contains(o.values, d.value) {
+		return fmt.Errorf("must be one of %s", strings.Join(o.values, ", "))
+	}
+
+	switch o.kind {
+	case dataUint:
+		if _, err := strconv.ParseUint(d.value, 10, 32); err != nil {
+			return errors.New("must be an unsigned integer")
+		}
+	case dataMode:
+		if _, err := strconv.ParseUint(d.value, 8, 32); err != nil {
+			return errors.New("must be an octal mode")
+		}
+	case dataSize:
+		if !sizeValue.MatchString(d.value) {
+			return errors.New("must be a size, e.g. 64m")
+		}
+	}
+
+	return nil
+}
+
+func validateOverlayData(data []mountData) error {
+	var lower, upper, work bool
+
+	for _, d := range data {
+		switch d.key {
+		case "lowerdir", "lowerdir+":
+			lower = true
+		case "upperdir":
+			upper = true
+		case "workdir":
+			work = true
+		}
+	}
+
+	if !lower {
+		return errors.New("overlay requires lowerdir")
+	}
+
+	if upper != work {
+		return errors.New("overlay requires both upperdir and workdir, or neither")
+	}
+
+	return nil
+}
@@ -0,0 +1,227 @@
+package anosys
+
+import (
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+type mountFlag struct {
+	flag  uintptr
+	clear bool
+	// superblock flags are passed to fsconfig by name, rather than applied as
+	// mount attributes
+	superblock bool
+	// only supported by mount(2), e.g. remount and options the fsconfig
+	// generic parser doesn't understand
+	legacy bool
+}
+
+var mountFlags = map[string]mountFlag{
+	"async":         {flag: unix.MS_SYNCHRONOUS, clear: true, superblock: true},
+	"atime":         {flag: unix.MS_NOATIME, clear: true},
+	"bind":          {flag: unix.MS_BIND},
+	"defaults":      {flag: 0},
+	"dev":           {flag: unix.MS_NODEV, clear: true},
+	"diratime":      {flag: unix.MS_NODIRATIME, clear: true},
+	"dirsync":       {flag: unix.MS_DIRSYNC, superblock: true},
+	"exec":          {flag: unix.MS_NOEXEC, clear: true},
+	"iversion":      {flag: unix.MS_I_VERSION, legacy: true},
+	"lazytime":      {flag: unix.MS_LAZYTIME, superblock: true},
+	"loud":          {flag: unix.MS_SILENT, clear: true, legacy: true},
+	"noatime":       {flag: unix.MS_NOATIME},
+	"nodev":         {flag: unix.MS_NODEV},
+	"nodiratime":    {flag: unix.MS_NODIRATIME},
+	"noexec":        {flag: unix.MS_NOEXEC},
+	"noiversion":    {flag: unix.MS_I_VERSION, clear: true, legacy: true},
+	"nolazytime":    {flag: unix.MS_LAZYTIME, clear: true, superblock: true},
+	"norelatime":    {flag: unix.MS_RELATIME, clear: true},
+	"nostrictatime": {flag: unix.MS_STRICTATIME, clear: true},
+	"nosuid":        {flag: unix.MS_NOSUID},
+	"nosymfollow":   {flag: unix.MS_NOSYMFOLLOW},
+	"rbind":         {flag: unix.MS_BIND | unix.MS_REC},
+	"relatime":      {flag: unix.MS_RELATIME},
+	"remount":       {flag: unix.MS_REMOUNT, legacy: true},
+	"ro":            {flag: unix.MS_RDONLY},
+	"rw":            {flag: unix.MS_RDONLY, clear: true},
+	"silent":        {flag: unix.MS_SILENT, legacy: true},
+	"strictatime":   {flag: unix.MS_STRICTATIME},
+	"suid":          {flag: unix.MS_NOSUID, clear: true},
+	"sync":          {flag: unix.MS_SYNCHRONOUS, superblock: true},
+}
+
+var mountPropagation = map[string]uintptr{
+	"private":     unix.MS_PRIVATE,
+	"rprivate":    unix.MS_PRIVATE | unix.MS_REC,
+	"shared":      unix.MS_SHARED,
+	"rshared":     unix.MS_SHARED | unix.MS_REC,
+	"slave":       unix.MS_SLAVE,
+	"rslave":      unix.MS_SLAVE | unix.MS_REC,
+	"unbindable":  unix.MS_UNBINDABLE,
+	"runbindable": unix.MS_UNBINDABLE | unix.MS_REC,
+}
+
+type mountAttr struct {
+	attr  uint64
+	clear bool
+	atime bool
+}
+
+var recursiveMountAttrs = map[string]mountAttr{
+	"rro":            {attr: unix.MOUNT_ATTR_RDONLY},
+	"rrw":            {attr: unix.MOUNT_ATTR_RDONLY, clear: true},
+	"rnosuid":        {attr: unix.MOUNT_ATTR_NOSUID},
+	"rsuid":          {attr: unix.MOUNT_ATTR_NOSUID, clear: true},
+	"rnodev":         {attr: unix.MOUNT_ATTR_NODEV},
+	"rdev":           {attr: unix.MOUNT_ATTR_NODEV, clear: true},
+	"rnoexec":        {attr: unix.MOUNT_ATTR_NOEXEC},
+	"rexec":          {attr: unix.MOUNT_ATTR_NOEXEC, clear: true},
+	"rnodiratime":    {attr: unix.MOUNT_ATTR_NODIRATIME},
+	"rdiratime":      {attr: unix.MOUNT_ATTR_NODIRATIME, clear: true},
+	"rrelatime":      {attr: unix.MOUNT_ATTR_RELATIME, atime: true},
+	"rnorelatime":    {attr: unix.MOUNT_ATTR_RELATIME, clear: true, atime: true},
+	"rnoatime":       {attr: unix.MOUNT_ATTR_NOATIME, atime: true},
+	"ratime":         {attr: unix.MOUNT_ATTR_NOATIME, clear: true, atime: true},
+	"rstrictatime":   {attr: unix.MOUNT_ATTR_STRICTATIME, atime: true},
+	"rnostrictatime": {attr: unix.MOUNT_ATTR_STRICTATIME, clear: true, atime: true},
+	"rnosymfollow":   {attr: unix.MOUNT_ATTR_NOSYMFOLLOW},
+	"rsymfollow":     {attr: unix.MOUNT_ATTR_NOSYMFOLLOW, clear: true},
+}
+
+// mount flags that have an equivalent mount attribute, for the new mount API
+var mountFlagAttrs = map[uintptr]uint64{
+	unix.MS_RDONLY:      unix.MOUNT_ATTR_RDONLY,
+	unix.MS_NOSUID:      unix.MOUNT_ATTR_NOSUID,
+	unix.MS_NODEV:       unix.MOUNT_ATTR_NODEV,
+	unix.MS_NOEXEC:      unix.MOUNT_ATTR_NOEXEC,
+	unix.MS_NODIRATIME:  unix.MOUNT_ATTR_NODIRATIME,
+	unix.MS_NOSYMFOLLOW: unix.MOUNT_ATTR_NOSYMFOLLOW,
+}
+
+var atimeFlagAttrs = map[uintptr]uint64{
+	unix.MS_NOATIME:     unix.MOUNT_ATTR_NOATIME,
+	unix.MS_STRICTATIME: unix.MOUNT_ATTR_STRICTATIME,
+	unix.MS_RELATIME:    unix.MOUNT_ATTR_RELATIME,
+}
+
+type mountConfig struct {
+	flags       uintptr
+	clearFlags  uintptr
+	superblock  []string
+	legacy      bool
+	propagation []uintptr
+	recAttr     unix.MountAttr
+	data        []mountData
+	// copy the existing contents of the destination into a tmpfs mount
+	copyUp bool
+}
+
+func parseMountOptions(options []string) *mountConfig {
+	cfg := &mountConfig{}
+
+	for _, opt := range options {
+		switch opt {
+		case "tmpcopyup":
+			cfg.copyUp = true
+			continue
+		case "notmpcopyup":
+			cfg.copyUp = false
+			continue
+		}
+
+		if f, ok := mountFlags[opt]; ok {
+			if f.clear {
+				cfg.flags &^= f.flag
+				cfg.clearFlags |= f.flag
+			} else {
+				cfg.flags |= f.flag
+				cfg.clearFlags &^= f.flag
+			}
+
+			if f.superblock {
+				cfg.superblock = append(cfg.superblock, opt)
+			}
+
+			if f.legacy {
+				cfg.legacy = true
+			}
+
+			continue
+		}
+
+		if p, ok := mountPropagation[opt]; ok {
+			cfg.propagation = append(cfg.propagation, p)
+			continue
+		}
+
+		if a, ok := recursiveMountAttrs[opt]; ok {
+			if a.atime {
+				// atime attributes are an enum rather than flags, so the
+				// current value needs clearing before setting another
+				cfg.recAttr.Attr_clr |= unix.MOUNT_ATTR__ATIME
+				cfg.recAttr.Attr_set &^= unix.MOUNT_ATTR__ATIME
+			}
+
+			if a.clear {
+				cfg.recAttr.Attr_clr |= a.attr
+				cfg.recAttr.Attr_set &^= a.attr
+			} else {
+				cfg.recAttr.Attr_set |= a.attr
+				if !a.atime {
+					cfg.recAttr.Attr_clr &^= a.attr
+				}
+			}
+
+			continue
+		}
+
+		cfg.data = append(cfg.data, parseMountData(opt))
+	}
+
+	return cfg
+}
+
+func (cfg *mountConfig) isBind() bool {
+	return cfg.flags&unix.MS_BIND != 0
+}
+
+func (cfg *mountConfig) isRecursive() bool {
+	return cfg.flags&unix.MS_REC != 0
+}
+
+func (cfg *mountConfig) hasRecursiveAttrs() bool {
+	return cfg.recAttr.Attr_set != 0 || cfg.recAttr.Attr_clr != 0
+}
+
+func (cfg *mountConfig) legacyData() string {
+	data := make([]string, len(cfg.data))
+	for i, d := range cfg.data {
+		data[i] = d.String()
+	}
+
+	return strings.Join(data, ",")
+}
+
+// mountAttrs converts the mount flags to mount attributes to set and clear.
+func (cfg *mountConfig) mountAttrs() (uint64, uint64) {
+	var set, clr uint64
+
+	for flag, attr := range mountFlagAttrs {
+		if cfg.flags&flag != 0 {
+			set |= attr
+		} else if cfg.clearFlags&flag != 0 {
+			clr |= attr
+		}
+	}
+
+	for flag, attr := range atimeFlagAttrs {
+		if cfg.flags&flag != 0 {
+			clr |= unix.MOUNT_ATTR__ATIME
+			set |= attr
+		} else if cfg.clearFlags&flag != 0 {
+			clr |= unix.MOUNT_ATTR__ATIME
+		}
+	}
+
+	return set, clr
+}
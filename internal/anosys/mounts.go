@@ -1,10 +1,11 @@
 package anosys
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
-	"strings"
 	"syscall"
 
 	"github.com/opencontainers/runtime-spec/specs-go"
@@ -12,72 +13,392 @@ import (
 	"golang.org/x/sys/unix"
 )
 
-func MountSpecMounts(mounts []specs.Mount, rootfs string) error {
-	for _, m := range mounts {
+func MountSpecMounts(
+	mounts []specs.Mount,
+	rootfs string,
+	mountFds map[int]int,
+) error {
+	for i, m := range mounts {
 		logrus.Debug("mounting: ", m)
 
-		var flags uintptr
-
-		/*
-			TODO: in Docker trying to mount cgroup mountpoint if cgroupv2 is enabled doesn't work
-						the call to `mount` results in an 'invalid argument' error
-						need to find out if that's the expected behaviour or not
-		*/
-		if m.Type == "cgroup" && IsUnifiedCGroupsMode() {
+		if m.Type == "cgroup" {
+			if err := mountCgroups(rootfs, m); err != nil {
+				return fmt.Errorf("mount cgroups (%s): %w", m.Destination, err)
+			}
 			continue
 		}
 
-		dest := filepath.Join(rootfs, m.Destination)
+		fd, ok := mountFds[i]
+		if !ok {
+			fd = -1
+		}
 
-		if _, err := os.Stat(dest); err != nil {
-			if !os.IsNotExist(err) {
-				return fmt.Errorf("exists (%s): %w", dest, err)
-			}
+		if err := mountSpecMount(rootfs, m, fd); err != nil {
+			return err
+		}
+	}
 
-			if err := os.MkdirAll(dest, os.ModeDir); err != nil {
-				return fmt.Errorf("make dir (%s): %w", dest, err)
-			}
+	return nil
+}
+
+// mountSpecMount mounts m in rootfs, or attaches the already prepared mount
+// fd, if not -1.
+func mountSpecMount(rootfs string, m specs.Mount, mountFd int) error {
+	cfg := parseMountOptions(m.Options)
+	if m.Type == "bind" {
+		cfg.flags |= unix.MS_BIND
+	}
+
+	logrus.Debug("data: ", cfg.data)
+
+	if !cfg.isBind() {
+		if err := validateMountData(m.Type, cfg.data); err != nil {
+			return fmt.Errorf("parse mount options (%s): %w", m.Destination, err)
 		}
+	}
 
-		var dataOptions []string
+	target, err := mountTarget(rootfs, m.Destination, m.Source, cfg)
+	if err != nil {
+		return fmt.Errorf("create mount target (%s): %w", m.Destination, err)
+	}
 
-		for _, opt := range m.Options {
-			// TODO: should this be setting the group id of the mount?
-			switch o := opt; {
-			case strings.HasPrefix(o, "gid"):
-				continue
-			}
+	// mount via the fd, so the target can't be swapped for a symlink
+	dest := ProcFdPath(target)
 
-			if f, ok := mountOptions[opt]; ok {
-				// bind mount propagation
-				if opt != "private" && opt != "rprivate" && opt != "shared" && opt != "rshared" && opt != "slave" && opt != "rslave" {
-					if f.invert {
-						flags &= f.flag
-					} else {
-						flags |= f.flag
-					}
-				}
-
-				if f.recursive {
-					flags |= unix.MS_REC
-				}
-			} else if strings.Contains(opt, "=") {
-				dataOptions = append(dataOptions, opt)
-			}
+	var remount bool
+	if mountFd != -1 {
+		err = AttachMount(mountFd, dest)
+	} else if cfg.flags&unix.MS_REMOUNT != 0 {
+		err = mountFilesystemLegacy(m.Source, dest, m.Type, cfg)
+	} else if cfg.isBind() {
+		remount, err = mountBind(m.Source, dest, cfg)
+	} else {
+		err = mountFilesystem(m.Source, dest, m.Type, cfg)
+	}
+	if err != nil {
+		target.Close()
+		return fmt.Errorf("mount spec mount (%s): %w", m.Destination, err)
+	}
+
+	copyUp := cfg.copyUp && m.Type == "tmpfs"
+
+	if !remount &&
+		!copyUp &&
+		!cfg.hasRecursiveAttrs() &&
+		len(cfg.propagation) == 0 {
+		target.Close()
+		return nil
+	}
+
+	// the target fd refers to the mountpoint underneath, so reopen to get
+	// the mount itself
+	mnt, err := OpenInRoot(rootfs, m.Destination, unix.O_PATH)
+	if err != nil {
+		target.Close()
+		return fmt.Errorf("open mount (%s): %w", m.Destination, err)
+	}
+	defer mnt.Close()
+
+	dest = ProcFdPath(mnt)
+
+	if copyUp {
+		err = copyDir(ProcFdPath(target), dest)
+	}
+	target.Close()
+	if err != nil {
+		return fmt.Errorf("copy up tmpfs (%s): %w", m.Destination, err)
+	}
+
+	if remount {
+		if err := remountBind(dest, cfg); err != nil {
+			return fmt.Errorf("%s: %w", m.Destination, err)
 		}
+	}
+
+	if err := setMountAttrsAndPropagation(dest, cfg); err != nil {
+		return fmt.Errorf("%s: %w", m.Destination, err)
+	}
 
-		logrus.Debug("data: ", dataOptions)
+	return nil
+}
 
-		if err := syscall.Mount(
-			m.Source,
+func setMountAttrsAndPropagation(dest string, cfg *mountConfig) error {
+	if cfg.hasRecursiveAttrs() {
+		if err := unix.MountSetattr(
+			unix.AT_FDCWD,
 			dest,
-			m.Type,
-			uintptr(flags),
-			strings.Join(dataOptions, ","),
+			unix.AT_RECURSIVE,
+			&cfg.recAttr,
 		); err != nil {
-			return fmt.Errorf("mount spec mount: %w", err)
+			return fmt.Errorf("set recursive mount attrs: %w", err)
+		}
+	}
+
+	for _, p := range cfg.propagation {
+		if err := syscall.Mount("", dest, "", p, ""); err != nil {
+			return fmt.Errorf("set mount propagation: %w", err)
 		}
 	}
 
 	return nil
 }
+
+func mountTarget(
+	rootfs, dest, source string,
+	cfg *mountConfig,
+) (*os.File, error) {
+	// bind mounts of files need a file to mount onto
+	if cfg.isBind() {
+		if fi, err := os.Stat(source); err == nil && !fi.IsDir() {
+			return CreateInRoot(rootfs, dest, 0644)
+		}
+	}
+
+	return MkdirAllInRoot(rootfs, dest, 0755)
+}
+
+func mountBind(source, dest string, cfg *mountConfig) (bool, error) {
+	if cfg.legacy {
+		return mountBindLegacy(source, dest, cfg)
+	}
+
+	var treeFlags uint = unix.OPEN_TREE_CLONE | unix.OPEN_TREE_CLOEXEC
+	if cfg.isRecursive() {
+		treeFlags |= unix.AT_RECURSIVE
+	}
+
+	fd, err := unix.OpenTree(unix.AT_FDCWD, source, treeFlags)
+	if err != nil {
+		if errors.Is(err, unix.ENOSYS) {
+			return mountBindLegacy(source, dest, cfg)
+		}
+		return false, fmt.Errorf("open tree (%s): %w", source, err)
+	}
+	defer unix.Close(fd)
+
+	if set, clr := cfg.mountAttrs(); set != 0 || clr != 0 {
+		if err := unix.MountSetattr(
+			fd,
+			"",
+			unix.AT_EMPTY_PATH,
+			&unix.MountAttr{Attr_set: set, Attr_clr: clr},
+		); err != nil {
+			return false, fmt.Errorf("set bind mount attrs: %w", err)
+		}
+	}
+
+	if err := unix.MoveMount(
+		fd,
+		"",
+		unix.AT_FDCWD,
+		dest,
+		unix.MOVE_MOUNT_F_EMPTY_PATH|unix.MOVE_MOUNT_T_SYMLINKS,
+	); err != nil {
+		return false, fmt.Errorf("move bind mount: %w", err)
+	}
+
+	return false, nil
+}
+
+// mountBindLegacy bind mounts with mount(2) and returns whether the mount
+// needs remounting to apply flags, which are ignored on the initial bind.
+func mountBindLegacy(source, dest string, cfg *mountConfig) (bool, error) {
+	if err := syscall.Mount(
+		source,
+		dest,
+		"",
+		cfg.flags&(unix.MS_BIND|unix.MS_REC),
+		"",
+	); err != nil {
+		return false, fmt.Errorf("bind mount: %w", err)
+	}
+
+	flags := cfg.flags &^ (unix.MS_BIND | unix.MS_REC | unix.MS_REMOUNT)
+
+	return flags != 0 || cfg.clearFlags != 0, nil
+}
+
+func remountBind(dest string, cfg *mountConfig) error {
+	flags := cfg.flags &^ (unix.MS_BIND | unix.MS_REC | unix.MS_REMOUNT)
+
+	// remounting can't clear locked flags, e.g. when in a user namespace, so
+	// keep any that aren't being explicitly cleared
+	var st unix.Statfs_t
+	if err := unix.Statfs(dest, &st); err != nil {
+		return fmt.Errorf("statfs bind mount: %w", err)
+	}
+	locked := uintptr(st.Flags) &
+		(unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC | unix.MS_RDONLY)
+	flags |= locked &^ cfg.clearFlags
+
+	if err := syscall.Mount(
+		"",
+		dest,
+		"",
+		unix.MS_BIND|unix.MS_REMOUNT|flags,
+		"",
+	); err != nil {
+		return fmt.Errorf("remount bind mount: %w", err)
+	}
+
+	return nil
+}
+
+func mountFilesystem(source, dest, fstype string, cfg *mountConfig) error {
+	if cfg.legacy {
+		return mountFilesystemLegacy(source, dest, fstype, cfg)
+	}
+
+	fsfd, err := unix.Fsopen(fstype, unix.FSOPEN_CLOEXEC)
+	if err != nil {
+		if errors.Is(err, unix.ENOSYS) {
+			return mountFilesystemLegacy(source, dest, fstype, cfg)
+		}
+		return fmt.Errorf("fsopen (%s): %w", fstype, err)
+	}
+	defer unix.Close(fsfd)
+
+	if source != "" {
+		if err := unix.FsconfigSetString(fsfd, "source", source); err != nil {
+			return fmt.Errorf("fsconfig source (%s): %w", source, err)
+		}
+	}
+
+	for _, opt := range cfg.superblock {
+		if err := unix.FsconfigSetFlag(fsfd, opt); err != nil {
+			return fmt.Errorf("fsconfig flag (%s): %w", opt, err)
+		}
+	}
+
+	for _, d := range cfg.data {
+		if d.hasValue {
+			err = unix.FsconfigSetString(fsfd, d.key, d.value)
+		} else {
+			err = unix.FsconfigSetFlag(fsfd, d.key)
+		}
+		if err != nil {
+			return fmt.Errorf("fsconfig option (%s): %w", d, err)
+		}
+	}
+
+	if err := unix.FsconfigCreate(fsfd); err != nil {
+		return fmt.Errorf("fsconfig create (%s): %w", fstype, err)
+	}
+
+	set, _ := cfg.mountAttrs()
+
+	mfd, err := unix.Fsmount(fsfd, unix.FSMOUNT_CLOEXEC, int(set))
+	if err != nil {
+		return fmt.Errorf("fsmount (%s): %w", fstype, err)
+	}
+	defer unix.Close(mfd)
+
+	if err := unix.MoveMount(
+		mfd,
+		"",
+		unix.AT_FDCWD,
+		dest,
+		unix.MOVE_MOUNT_F_EMPTY_PATH|unix.MOVE_MOUNT_T_SYMLINKS,
+	); err != nil {
+		return fmt.Errorf("move mount: %w", err)
+	}
+
+	return nil
+}
+
+func mountFilesystemLegacy(
+	source, dest, fstype string,
+	cfg *mountConfig,
+) error {
+	if err := syscall.Mount(
+		source,
+		dest,
+		fstype,
+		cfg.flags,
+		cfg.legacyData(),
+	); err != nil {
+		return fmt.Errorf("mount: %w", err)
+	}
+
+	return nil
+}
+
+// copyDir copies the contents of src into dst, preserving modes and
+// ownership.
+func copyDir(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		s := filepath.Join(src, e.Name())
+		d := filepath.Join(dst, e.Name())
+
+		fi, err := os.Lstat(s)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case fi.IsDir():
+			if err := os.Mkdir(d, fi.Mode().Perm()); err != nil {
+				return err
+			}
+			if err := copyDir(s, d); err != nil {
+				return err
+			}
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(s)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(target, d); err != nil {
+				return err
+			}
+		case fi.Mode().IsRegular():
+			if err := copyFile(s, d, fi.Mode().Perm()); err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			if err := os.Lchown(d, int(st.Uid), int(st.Gid)); err != nil {
+				return err
+			}
+		}
+
+		if fi.Mode()&os.ModeSymlink == 0 {
+			// mode is masked by umask on create
+			mode := fi.Mode() &
+				(os.ModePerm | os.ModeSticky | os.ModeSetuid | os.ModeSetgid)
+			if err := os.Chmod(d, mode); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
@@ -1,10 +1,23 @@
 package anosys
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"golang.org/x/sys/unix"
 )
 
+// ioctl_ns(2)
+const (
+	nsGetParent = 0xb702
+	nsGetNSType = 0xb703
+)
+
 var NamespaceFlags = map[specs.LinuxNamespaceType]uintptr{
 	specs.PIDNamespace:     unix.CLONE_NEWPID,
 	specs.NetworkNamespace: unix.CLONE_NEWNET,
@@ -26,3 +39,139 @@ var NamespaceEnvs = map[specs.LinuxNamespaceType]string{
 	specs.CgroupNamespace:  "cgroup",
 	specs.TimeNamespace:    "time",
 }
+
+func NamespaceType(fd int) (uintptr, error) {
+	t, err := unix.IoctlRetInt(fd, nsGetNSType)
+	if err != nil {
+		return 0, fmt.Errorf("get namespace type: %w", err)
+	}
+
+	return uintptr(t), nil
+}
+
+func PersistNamespaces(
+	pid int,
+	types []specs.LinuxNamespaceType,
+	dir string,
+) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create namespaces dir: %w", err)
+	}
+
+	// bind mounting mount namespaces onto a shared mount would propagate
+	// them back into the namespace they reference, so make the dir private
+	if err := syscall.Mount(
+		dir,
+		dir,
+		"",
+		unix.MS_BIND|unix.MS_REC,
+		"",
+	); err != nil {
+		return fmt.Errorf("bind mount namespaces dir: %w", err)
+	}
+
+	if err := syscall.Mount(
+		"",
+		dir,
+		"",
+		unix.MS_PRIVATE,
+		"",
+	); err != nil {
+		return fmt.Errorf("make namespaces dir private: %w", err)
+	}
+
+	for _, t := range types {
+		name := NamespaceEnvs[t]
+		source := fmt.Sprintf("/proc/%d/ns/%s", pid, name)
+		target := filepath.Join(dir, name)
+
+		f, err := os.Create(target)
+		if err != nil {
+			return fmt.Errorf("create namespace file (%s): %w", target, err)
+		}
+		f.Close()
+
+		if err := syscall.Mount(
+			source,
+			target,
+			"",
+			unix.MS_BIND,
+			"",
+		); err != nil {
+			return fmt.Errorf("bind mount namespace (%s): %w", source, err)
+		}
+	}
+
+	return nil
+}
+
+func UnpersistNamespaces(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read namespaces dir: %w", err)
+	}
+
+	for _, e := range entries {
+		target := filepath.Join(dir, e.Name())
+
+		if err := syscall.Unmount(
+			target,
+			unix.MNT_DETACH,
+		); err != nil && !errors.Is(err, unix.EINVAL) {
+			return fmt.Errorf("unmount namespace (%s): %w", target, err)
+		}
+	}
+
+	if err := syscall.Unmount(
+		dir,
+		unix.MNT_DETACH,
+	); err != nil && !errors.Is(err, unix.EINVAL) {
+		return fmt.Errorf("unmount namespaces dir: %w", err)
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("remove namespaces dir: %w", err)
+	}
+
+	return nil
+}
+
+// WriteIDMappings maps the user namespace of the process, for when it's
+// created by the process itself rather than on clone. As on clone, setgroups
+// is denied first.
+func WriteIDMappings(
+	pid int,
+	uidMappings []syscall.SysProcIDMap,
+	gidMappings []syscall.SysProcIDMap,
+) error {
+	if err := os.WriteFile(
+		fmt.Sprintf("/proc/%d/setgroups", pid),
+		[]byte("deny"),
+		0644,
+	); err != nil {
+		return fmt.Errorf("deny setgroups: %w", err)
+	}
+
+	for file, mappings := range map[string][]syscall.SysProcIDMap{
+		"uid_map": uidMappings,
+		"gid_map": gidMappings,
+	} {
+		var b strings.Builder
+		for _, m := range mappings {
+			fmt.Fprintf(&b, "%d %d %d\n", m.ContainerID, m.HostID, m.Size)
+		}
+
+		if err := os.WriteFile(
+			fmt.Sprintf("/proc/%d/%s", pid, file),
+			[]byte(b.String()),
+			0644,
+		); err != nil {
+			return fmt.Errorf("write %s: %w", file, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package anosys
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// MountOverlayRootfs assembles an overlay from lowerdirs, topmost first,
+// with its upper and work dirs, and the merged mountpoint, in dir.
+func MountOverlayRootfs(lowerdirs []string, dir string) error {
+	if len(lowerdirs) == 0 {
+		return errors.New("overlay rootfs requires at least one layer")
+	}
+
+	for _, l := range lowerdirs {
+		// separators in overlay options, which can't be escaped for legacy mounts
+		if strings.ContainsAny(l, ":,") {
+			return fmt.Errorf("invalid overlay layer path (%s)", l)
+		}
+	}
+
+	upper := filepath.Join(dir, "upper")
+	work := filepath.Join(dir, "work")
+	merged := filepath.Join(dir, "merged")
+
+	for _, d := range []string{upper, work, merged} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return fmt.Errorf("create overlay dir (%s): %w", d, err)
+		}
+	}
+
+	if err := mountOverlay(lowerdirs, upper, work, merged); err != nil {
+		return fmt.Errorf("mount overlay rootfs: %w", err)
+	}
+
+	return nil
+}
+
+// mountOverlay adds each layer with its own lowerdir+ option, since the
+// value of a single lowerdir option is limited to 256 bytes. Kernels < 6.8
+// don't support lowerdir+, so fall back to a legacy mount, where the whole
+// data is limited to a page instead.
+func mountOverlay(lowerdirs []string, upper, work, merged string) error {
+	fsfd, err := unix.Fsopen("overlay", unix.FSOPEN_CLOEXEC)
+	if errors.Is(err, unix.ENOSYS) {
+		return mountOverlayLegacy(lowerdirs, upper, work, merged)
+	}
+	if err != nil {
+		return fmt.Errorf("fsopen overlay: %w", err)
+	}
+	defer unix.Close(fsfd)
+
+	for i, l := range lowerdirs {
+		if err := unix.FsconfigSetString(fsfd, "lowerdir+", l); err != nil {
+			if i == 0 && errors.Is(err, unix.EINVAL) {
+				return mountOverlayLegacy(lowerdirs, upper, work, merged)
+			}
+			return fmt.Errorf("fsconfig lowerdir (%s): %w", l, err)
+		}
+	}
+
+	for _, o := range []struct{ key, value string }{
+		{"upperdir", upper},
+		{"workdir", work},
+	} {
+		if err := unix.FsconfigSetString(fsfd, o.key, o.value); err != nil {
+			return fmt.Errorf("fsconfig %s (%s): %w", o.key, o.value, err)
+		}
+	}
+
+	if err := unix.FsconfigCreate(fsfd); err != nil {
+		return fmt.Errorf("fsconfig create: %w", err)
+	}
+
+	mfd, err := unix.Fsmount(fsfd, unix.FSMOUNT_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("fsmount: %w", err)
+	}
+	defer unix.Close(mfd)
+
+	if err := unix.MoveMount(
+		mfd,
+		"",
+		unix.AT_FDCWD,
+		merged,
+		unix.MOVE_MOUNT_F_EMPTY_PATH,
+	); err != nil {
+		return fmt.Errorf("move mount: %w", err)
+	}
+
+	return nil
+}
+
+func mountOverlayLegacy(lowerdirs []string, upper, work, merged string) error {
+	data := fmt.Sprintf(
+		"lowerdir=%s,upperdir=%s,workdir=%s",
+		strings.Join(lowerdirs, ":"),
+		upper,
+		work,
+	)
+
+	if len(data) >= os.Getpagesize() {
+		return fmt.Errorf("overlay layer paths too long (%d bytes)", len(data))
+	}
+
+	if err := syscall.Mount("overlay", merged, "overlay", 0, data); err != nil {
+		return fmt.Errorf("mount: %w", err)
+	}
+
+	return nil
+}
+
+func UnmountOverlayRootfs(dir string) error {
+	merged := filepath.Join(dir, "merged")
+
+	if err := syscall.Unmount(
+		merged,
+		unix.MNT_DETACH,
+	); err != nil && !errors.Is(err, unix.EINVAL) &&
+		!errors.Is(err, unix.ENOENT) {
+		return fmt.Errorf("unmount overlay rootfs: %w", err)
+	}
+
+	return nil
+}
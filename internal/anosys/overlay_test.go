@@ -0,0 +1,58 @@
+package anosys
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMountOverlayRootfsManyLongLayers(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("mounting overlay requires root")
+	}
+
+	dir := t.TempDir()
+
+	// well over the 256 byte limit of a single fsconfig value
+	var layers []string
+	for i := 0; i < 20; i++ {
+		layer := filepath.Join(
+			dir,
+			"layers",
+			fmt.Sprintf("%02d-%s", i, strings.Repeat("x", 64)),
+		)
+		if err := os.MkdirAll(layer, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(
+			filepath.Join(layer, fmt.Sprintf("file%02d", i)),
+			nil,
+			0644,
+		); err != nil {
+			t.Fatal(err)
+		}
+
+		layers = append(layers, layer)
+	}
+
+	rootfs := filepath.Join(dir, "rootfs")
+
+	if err := MountOverlayRootfs(layers, rootfs); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := UnmountOverlayRootfs(rootfs); err != nil {
+			t.Error(err)
+		}
+	})
+
+	for i := range layers {
+		name := fmt.Sprintf("file%02d", i)
+		if _, err := os.Stat(filepath.Join(rootfs, "merged", name)); err != nil {
+			t.Errorf("layer file (%s) not in merged rootfs: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package anosys
+
+import (
+	"fmt"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// personality(2) domains
+var personalityDomains = map[specs.LinuxPersonalityDomain]uintptr{
+	specs.PerLinux:   0x0000,
+	specs.PerLinux32: 0x0008,
+}
+
+func SetPersonality(personality *specs.LinuxPersonality) error {
+	domain, ok := personalityDomains[personality.Domain]
+	if !ok {
+		return fmt.Errorf("unsupported personality domain: %s", personality.Domain)
+	}
+
+	// the spec doesn't define any flags yet
+	if len(personality.Flags) > 0 {
+		return fmt.Errorf("unsupported personality flags: %v", personality.Flags)
+	}
+
+	if _, _, errno := unix.Syscall(
+		unix.SYS_PERSONALITY,
+		domain,
+		0,
+		0,
+	); errno != 0 {
+		return fmt.Errorf("set personality (%s): %w", personality.Domain, errno)
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package anosys
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+
+	"golang.org/x/sys/unix"
+)
+
+var procPIDNamespacePath = regexp.MustCompile(
+	`^/proc/(\d+)/ns/(pid|pid_for_children)$`,
+)
+
+func ValidatePIDNamespace(path string, fd int) error {
+	if m := procPIDNamespacePath.FindStringSubmatch(path); m != nil {
+		stat, err := os.ReadFile(fmt.Sprintf("/proc/%s/stat", m[1]))
+		if err != nil {
+			return fmt.Errorf("read stat of namespace process (%s): %w", m[1], err)
+		}
+
+		// state is the field following the command, which may contain spaces
+		fields := bytes.Fields(stat[bytes.LastIndexByte(stat, ')')+1:])
+		if len(fields) == 0 {
+			return fmt.Errorf("parse stat of namespace process (%s)", m[1])
+		}
+
+		if state := string(fields[0]); state == "Z" || state == "X" {
+			return fmt.Errorf("namespace process (%s) has exited", m[1])
+		}
+	}
+
+	descendant, err := isDescendantPIDNamespace(fd)
+	if err != nil {
+		return err
+	}
+
+	if !descendant {
+		return errors.New("pid namespace is not a descendant of the runtime pid namespace")
+	}
+
+	return nil
+}
+
+func isDescendantPIDNamespace(fd int) (bool, error) {
+	var self unix.Stat_t
+	if err := unix.Stat("/proc/self/ns/pid", &self); err != nil {
+		return false, fmt.Errorf("stat runtime pid namespace: %w", err)
+	}
+
+	cur, err := unix.Dup(fd)
+	if err != nil {
+		return false, fmt.Errorf("dup pid namespace fd: %w", err)
+	}
+
+	for {
+		var st unix.Stat_t
+		if err := unix.Fstat(cur, &st); err != nil {
+			unix.Close(cur)
+			return false, fmt.Errorf("stat pid namespace: %w", err)
+		}
+
+		if st.Dev == self.Dev && st.Ino == self.Ino {
+			unix.Close(cur)
+			return true, nil
+		}
+
+		parent, err := unix.IoctlRetInt(cur, nsGetParent)
+		unix.Close(cur)
+		if err != nil {
+			// the parent is outside of the runtime pid namespace
+			if errors.Is(err, unix.EPERM) {
+				return false, nil
+			}
+			return false, fmt.Errorf("get parent pid namespace: %w", err)
+		}
+
+		cur = parent
+	}
+}
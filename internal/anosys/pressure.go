@@ -0,0 +1,132 @@
+package anosys
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/cgroups/v3"
+	"golang.org/x/sys/unix"
+)
+
+type PSIData struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+	// microseconds
+	Total uint64 `json:"total"`
+}
+
+type PSIStats struct {
+	Some *PSIData `json:"some,omitempty"`
+	Full *PSIData `json:"full,omitempty"`
+}
+
+type PressureStats struct {
+	CPU    *PSIStats `json:"cpu,omitempty"`
+	Memory *PSIStats `json:"memory,omitempty"`
+	IO     *PSIStats `json:"io,omitempty"`
+}
+
+// V2CGroupPressure returns the pressure stall information of the cgroup of
+// pid. Resources without PSI, e.g. when disabled in the kernel, are nil.
+func V2CGroupPressure(pid int) (*PressureStats, error) {
+	if !IsUnifiedCGroupsMode() {
+		return nil, errors.New("pressure stall information requires cgroup v2")
+	}
+
+	_, path, err := cgroups.ParseCgroupFileUnified(
+		fmt.Sprintf("/proc/%d/cgroup", pid),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("parse cgroup of pid (%d): %w", pid, err)
+	}
+
+	dir := filepath.Join(cgroupMountsDir, path)
+
+	var stats PressureStats
+
+	for name, psi := range map[string]**PSIStats{
+		"cpu.pressure":    &stats.CPU,
+		"memory.pressure": &stats.Memory,
+		"io.pressure":     &stats.IO,
+	} {
+		if *psi, err = readPSIFile(filepath.Join(dir, name)); err != nil {
+			return nil, err
+		}
+	}
+
+	return &stats, nil
+}
+
+// readPSIFile parses a pressure file, e.g.
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func readPSIFile(path string) (*PSIStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		// not supported by the kernel, or disabled with psi=0
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, unix.EOPNOTSUPP) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open pressure file (%s): %w", path, err)
+	}
+	defer f.Close()
+
+	var stats PSIStats
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		var data PSIData
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf(
+					"parse pressure file (%s): invalid field: %s",
+					path,
+					field,
+				)
+			}
+
+			switch key {
+			case "avg10":
+				data.Avg10, err = strconv.ParseFloat(value, 64)
+			case "avg60":
+				data.Avg60, err = strconv.ParseFloat(value, 64)
+			case "avg300":
+				data.Avg300, err = strconv.ParseFloat(value, 64)
+			case "total":
+				data.Total, err = strconv.ParseUint(value, 10, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("parse pressure file (%s): %w", path, err)
+			}
+		}
+
+		switch fields[0] {
+		case "some":
+			stats.Some = &data
+		case "full":
+			stats.Full = &data
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		// reading fails, rather than opening, when psi is disabled at runtime
+		if errors.Is(err, unix.EOPNOTSUPP) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read pressure file (%s): %w", path, err)
+	}
+
+	return &stats, nil
+}
@@ -2,20 +2,19 @@ package anosys
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
 	"syscall"
 )
 
 func MountProc(containerRootfs string) error {
-	containerProc := filepath.Join(containerRootfs, "proc")
-	if err := os.MkdirAll(containerProc, 0666); err != nil {
+	containerProc, err := MkdirAllInRoot(containerRootfs, "proc", 0555)
+	if err != nil {
 		return fmt.Errorf("create proc dir: %w", err)
 	}
+	defer containerProc.Close()
 
 	if err := syscall.Mount(
 		"proc",
-		containerProc,
+		ProcFdPath(containerProc),
 		"proc",
 		uintptr(0),
 		"",
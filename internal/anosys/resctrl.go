@@ -0,0 +1,233 @@
+package anosys
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// ResctrlRoot is where the resctrl filesystem is mounted. It's a var so it
+// can be pointed at a fake directory, e.g. in tests.
+var ResctrlRoot = "/sys/fs/resctrl"
+
+// reserved names in the resctrl root, which can't be used as a CLOS
+var resctrlReserved = []string{
+	"info",
+	"mon_groups",
+	"mon_data",
+	"tasks",
+	"cpus",
+	"cpus_list",
+	"schemata",
+	"mode",
+	"size",
+}
+
+// ResctrlEnabled returns whether the resctrl filesystem is mounted.
+func ResctrlEnabled() bool {
+	fi, err := os.Stat(filepath.Join(ResctrlRoot, "info"))
+
+	return err == nil && fi.IsDir()
+}
+
+// ResctrlClosID returns the name of the CLOS group for the container, which
+// is the container ID, unless specified.
+func ResctrlClosID(containerID string, rdt *specs.LinuxIntelRdt) string {
+	if rdt.ClosID != "" {
+		return rdt.ClosID
+	}
+
+	return containerID
+}
+
+// AddResctrlGroup adds pid to the CLOS group, creating it and writing the
+// schemata if it doesn't already exist, and returns whether it was created.
+func AddResctrlGroup(
+	closID string,
+	rdt *specs.LinuxIntelRdt,
+	pid int,
+) (bool, error) {
+	if !ResctrlEnabled() {
+		return false, errors.New("resctrl is not mounted")
+	}
+
+	if closID == "." ||
+		closID == ".." ||
+		strings.Contains(closID, "/") ||
+		slices.Contains(resctrlReserved, closID) {
+		return false, fmt.Errorf("invalid clos id: %s", closID)
+	}
+
+	if err := checkResctrlMonitoring(rdt); err != nil {
+		return false, err
+	}
+
+	var schemata []string
+	if rdt.L3CacheSchema != "" {
+		schemata = append(schemata, rdt.L3CacheSchema)
+	}
+	if rdt.MemBwSchema != "" {
+		schemata = append(schemata, rdt.MemBwSchema)
+	}
+
+	dir := filepath.Join(ResctrlRoot, closID)
+
+	created := true
+	if err := os.Mkdir(dir, 0755); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			return false, fmt.Errorf("create clos group (%s): %w", closID, err)
+		}
+		created = false
+	}
+
+	if created {
+		// each schema is written separately, so the kernel reports which is
+		// invalid
+		for _, s := range schemata {
+			if err := writeResctrlFile(dir, "schemata", s); err != nil {
+				os.Remove(dir)
+				return false, err
+			}
+		}
+	} else if err := checkResctrlSchemata(dir, schemata); err != nil {
+		return false, err
+	}
+
+	if err := writeResctrlFile(dir, "tasks", strconv.Itoa(pid)); err != nil {
+		if created {
+			os.Remove(dir)
+		}
+		return false, err
+	}
+
+	return created, nil
+}
+
+// DeleteResctrlGroup removes the CLOS group. Any remaining tasks are moved
+// back to the default group by the kernel.
+func DeleteResctrlGroup(closID string) error {
+	if err := os.Remove(
+		filepath.Join(ResctrlRoot, closID),
+	); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("delete clos group (%s): %w", closID, err)
+	}
+
+	return nil
+}
+
+func writeResctrlFile(dir, name, value string) error {
+	if err := os.WriteFile(
+		filepath.Join(dir, name),
+		[]byte(value+"\n"),
+		0644,
+	); err != nil {
+		return fmt.Errorf("write resctrl %s (%s): %w", name, value, err)
+	}
+
+	return nil
+}
+
+// checkResctrlSchemata checks an existing group has the expected schemata,
+// since a group can be shared between containers.
+func checkResctrlSchemata(dir string, schemata []string) error {
+	f, err := os.Open(filepath.Join(dir, "schemata"))
+	if err != nil {
+		return fmt.Errorf("open resctrl schemata: %w", err)
+	}
+	defer f.Close()
+
+	existing := map[string]string{}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if resource, _, ok := strings.Cut(line, ":"); ok {
+			existing[resource] = line
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read resctrl schemata: %w", err)
+	}
+
+	for _, s := range schemata {
+		for _, line := range strings.Split(s, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+
+			resource, _, _ := strings.Cut(line, ":")
+			if !resctrlSchemaEqual(existing[resource], line) {
+				return fmt.Errorf(
+					"existing clos group schema (%s) doesn't match (%s)",
+					existing[resource],
+					line,
+				)
+			}
+		}
+	}
+
+	return nil
+}
+
+// resctrlSchemaEqual compares schema lines, ignoring the order of domains and
+// the kernel's zero padding of bitmasks.
+func resctrlSchemaEqual(a, b string) bool {
+	domains := func(s string) map[string]string {
+		_, list, _ := strings.Cut(s, ":")
+
+		m := map[string]string{}
+		for _, d := range strings.Split(list, ";") {
+			id, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+
+			trimmed := strings.TrimLeft(strings.ToLower(value), "0")
+			if trimmed == "" {
+				trimmed = "0"
+			}
+			m[id] = trimmed
+		}
+
+		return m
+	}
+
+	existing := domains(a)
+	for id, value := range domains(b) {
+		if existing[id] != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+func checkResctrlMonitoring(rdt *specs.LinuxIntelRdt) error {
+	if !rdt.EnableCMT && !rdt.EnableMBM {
+		return nil
+	}
+
+	b, err := os.ReadFile(
+		filepath.Join(ResctrlRoot, "info", "L3_MON", "mon_features"),
+	)
+	if err != nil {
+		return fmt.Errorf("read resctrl monitoring features: %w", err)
+	}
+
+	features := strings.Fields(string(b))
+
+	if rdt.EnableCMT && !slices.Contains(features, "llc_occupancy") {
+		return errors.New("cache monitoring (cmt) not supported")
+	}
+
+	if rdt.EnableMBM && !slices.Contains(features, "mbm_total_bytes") {
+		return errors.New("memory bandwidth monitoring (mbm) not supported")
+	}
+
+	return nil
+}
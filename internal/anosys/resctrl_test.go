@@ -0,0 +1,280 @@
+package anosys
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// fakeResctrl points ResctrlRoot at a temp dir laid out like a mounted
+// resctrl filesystem, with the monitoring features given.
+func fakeResctrl(t *testing.T, monFeatures string) string {
+	t.Helper()
+
+	root := t.TempDir()
+
+	prev := ResctrlRoot
+	ResctrlRoot = root
+	t.Cleanup(func() { ResctrlRoot = prev })
+
+	if err := os.MkdirAll(filepath.Join(root, "info", "L3_MON"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(
+		filepath.Join(root, "info", "L3_MON", "mon_features"),
+		[]byte(monFeatures),
+		0644,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	return root
+}
+
+func TestAddResctrlGroup(t *testing.T) {
+	root := fakeResctrl(t, "")
+
+	created, err := AddResctrlGroup(
+		"clos1",
+		&specs.LinuxIntelRdt{L3CacheSchema: "L3:0=ff;1=f0"},
+		123,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !created {
+		t.Error("expected group to be created")
+	}
+
+	if got := readTestFile(
+		t,
+		filepath.Join(root, "clos1", "schemata"),
+	); got != "L3:0=ff;1=f0\n" {
+		t.Errorf("schemata = %q", got)
+	}
+
+	if got := readTestFile(
+		t,
+		filepath.Join(root, "clos1", "tasks"),
+	); got != "123\n" {
+		t.Errorf("tasks = %q", got)
+	}
+}
+
+func TestAddResctrlGroupExisting(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		schemata string
+		schema   string
+		wantErr  bool
+	}{
+		{
+			name:     "matching",
+			schemata: "L3:0=00ff;1=00f0\nMB:0=100;1=100\n",
+			schema:   "L3:1=f0;0=ff",
+		},
+		{
+			name:     "different",
+			schemata: "L3:0=00ff;1=00f0\n",
+			schema:   "L3:0=f;1=f0",
+			wantErr:  true,
+		},
+		{
+			name:     "missing resource",
+			schemata: "L3:0=00ff;1=00f0\n",
+			schema:   "MB:0=50",
+			wantErr:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := fakeResctrl(t, "")
+
+			dir := filepath.Join(root, "shared")
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.WriteFile(
+				filepath.Join(dir, "schemata"),
+				[]byte(tc.schemata),
+				0644,
+			); err != nil {
+				t.Fatal(err)
+			}
+
+			created, err := AddResctrlGroup(
+				"shared",
+				&specs.LinuxIntelRdt{L3CacheSchema: tc.schema},
+				123,
+			)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tc.wantErr)
+			}
+
+			if created {
+				t.Error("expected existing group not to be created")
+			}
+
+			// an existing group is kept, even if it doesn't match
+			if _, err := os.Stat(dir); err != nil {
+				t.Errorf("existing group removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestAddResctrlGroupInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		closID string
+		rdt    *specs.LinuxIntelRdt
+	}{
+		{name: "dot", closID: ".", rdt: &specs.LinuxIntelRdt{}},
+		{name: "dotdot", closID: "..", rdt: &specs.LinuxIntelRdt{}},
+		{name: "path", closID: "a/b", rdt: &specs.LinuxIntelRdt{}},
+		{name: "reserved", closID: "info", rdt: &specs.LinuxIntelRdt{}},
+		{
+			name:   "cmt unsupported",
+			closID: "clos1",
+			rdt:    &specs.LinuxIntelRdt{EnableCMT: true},
+		},
+		{
+			name:   "mbm unsupported",
+			closID: "clos1",
+			rdt:    &specs.LinuxIntelRdt{EnableMBM: true},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := fakeResctrl(t, "llc_occupancy_other")
+
+			if _, err := AddResctrlGroup(tc.closID, tc.rdt, 123); err == nil {
+				t.Error("expected error")
+			}
+
+			if _, err := os.Stat(filepath.Join(root, "clos1")); err == nil {
+				t.Error("group created on error")
+			}
+		})
+	}
+}
+
+func TestAddResctrlGroupMonitoring(t *testing.T) {
+	fakeResctrl(t, "llc_occupancy\nmbm_total_bytes\nmbm_local_bytes\n")
+
+	if _, err := AddResctrlGroup(
+		"clos1",
+		&specs.LinuxIntelRdt{EnableCMT: true, EnableMBM: true},
+		123,
+	); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddResctrlGroupNotMounted(t *testing.T) {
+	prev := ResctrlRoot
+	ResctrlRoot = t.TempDir()
+	t.Cleanup(func() { ResctrlRoot = prev })
+
+	if _, err := AddResctrlGroup(
+		"clos1",
+		&specs.LinuxIntelRdt{},
+		123,
+	); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestCheckResctrlSchemata(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		existing string
+		schemata []string
+		wantErr  bool
+	}{
+		{name: "none", existing: "L3:0=ff\n"},
+		{
+			name:     "equal",
+			existing: "L3:0=ff\nMB:0=100\n",
+			schemata: []string{"L3:0=ff", "MB:0=100"},
+		},
+		{
+			name:     "multiline",
+			existing: "L3:0=ff\nMB:0=100\n",
+			schemata: []string{"L3:0=ff\nMB:0=100\n"},
+		},
+		{
+			name:     "different",
+			existing: "L3:0=ff\nMB:0=100\n",
+			schemata: []string{"MB:0=50"},
+			wantErr:  true,
+		},
+		{
+			name:     "missing",
+			existing: "L3:0=ff\n",
+			schemata: []string{"MB:0=100"},
+			wantErr:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			if err := os.WriteFile(
+				filepath.Join(dir, "schemata"),
+				[]byte(tc.existing),
+				0644,
+			); err != nil {
+				t.Fatal(err)
+			}
+
+			err := checkResctrlSchemata(dir, tc.schemata)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("err = %v, want error %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestResctrlSchemaEqual(t *testing.T) {
+	for _, tc := range []struct {
+		a, b  string
+		equal bool
+	}{
+		{"L3:0=ff;1=ff", "L3:0=ff;1=ff", true},
+		{"L3:0=ff;1=ff", "L3:1=ff;0=ff", true},
+		{"L3:0=000ff;1=0ff", "L3:0=ff;1=FF", true},
+		{"L3:0=0000;1=ff", "L3:0=0", true},
+		{"MB:0=100;1=100", "MB:0=100", true},
+		{"L3:0=ff;1=ff", "L3:0=f0", false},
+		{"L3:0=ff", "L3:0=ff;1=ff", false},
+		{"", "L3:0=ff", false},
+	} {
+		if got := resctrlSchemaEqual(tc.a, tc.b); got != tc.equal {
+			t.Errorf("resctrlSchemaEqual(%q, %q) = %t", tc.a, tc.b, got)
+		}
+	}
+}
+
+func TestDeleteResctrlGroup(t *testing.T) {
+	root := fakeResctrl(t, "")
+
+	if err := os.Mkdir(filepath.Join(root, "clos1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DeleteResctrlGroup("clos1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "clos1")); !os.IsNotExist(err) {
+		t.Errorf("group not deleted: %v", err)
+	}
+
+	// already deleted
+	if err := DeleteResctrlGroup("clos1"); err != nil {
+		t.Errorf("delete missing group: %v", err)
+	}
+}
@@ -0,0 +1,310 @@
+package anosys
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const maxSymlinks = 255
+
+// OpenInRoot opens path as if rootfs were the root directory, so that
+// symlinks and '..' can't resolve to anywhere outside of it.
+func OpenInRoot(rootfs, path string, flags int) (*os.File, error) {
+	root, err := unix.Open(
+		rootfs,
+		unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC,
+		0,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("open rootfs (%s): %w", rootfs, err)
+	}
+	defer unix.Close(root)
+
+	return openInRootFd(root, rootfs, path, flags)
+}
+
+func openInRootFd(
+	root int,
+	rootfs, path string,
+	flags int,
+) (*os.File, error) {
+	fd, err := unix.Openat2(root, path, &unix.OpenHow{
+		Flags:   uint64(flags | unix.O_CLOEXEC),
+		Resolve: unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS,
+	})
+	if errors.Is(err, unix.ENOSYS) {
+		// openat2 isn't available (< 5.6), so resolve in userspace instead,
+		// which is racy, but still prevents escaping via symlinks on disk
+		resolved, joinErr := secureJoin(rootfs, path)
+		if joinErr != nil {
+			return nil, joinErr
+		}
+
+		fd, err = unix.Open(resolved, flags|unix.O_CLOEXEC|unix.O_NOFOLLOW, 0)
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "open in root", Path: path, Err: err}
+	}
+
+	return os.NewFile(uintptr(fd), filepath.Join(rootfs, path)), nil
+}
+
+// MkdirAllInRoot creates path, and any missing parents, inside rootfs and
+// returns an O_PATH handle to it.
+func MkdirAllInRoot(
+	rootfs, path string,
+	mode os.FileMode,
+) (*os.File, error) {
+	root, err := unix.Open(
+		rootfs,
+		unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC,
+		0,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("open rootfs (%s): %w", rootfs, err)
+	}
+	defer unix.Close(root)
+
+	dir, err := openInRootFd(root, rootfs, "/", unix.O_PATH|unix.O_DIRECTORY)
+	if err != nil {
+		return nil, err
+	}
+
+	// current isn't cleaned, so '..' after a symlink is resolved by the
+	// kernel relative to the symlink target, not lexically
+	current := ""
+	parts := strings.Split(path, "/")
+	links := 0
+
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+
+		if part == "" || part == "." {
+			continue
+		}
+
+		next := current + "/" + part
+
+		// created relative to the already resolved parent, so can't be
+		// redirected; if it's a symlink it'll be resolved in root below
+		if part != ".." {
+			if err := unix.Mkdirat(
+				int(dir.Fd()),
+				part,
+				uint32(mode.Perm()),
+			); err != nil && !errors.Is(err, unix.EEXIST) {
+				dir.Close()
+				return nil, fmt.Errorf("mkdir in root (%s): %w", next, err)
+			}
+		}
+
+		nextDir, err := openInRootFd(
+			root,
+			rootfs,
+			next,
+			unix.O_PATH|unix.O_DIRECTORY,
+		)
+		if errors.Is(err, unix.ENOENT) && part != ".." {
+			// a dangling symlink, so continue by creating its target instead
+			target, linkErr := readlinkat(int(dir.Fd()), part)
+			if linkErr == nil {
+				links++
+				if links > maxSymlinks {
+					dir.Close()
+					return nil, &os.PathError{
+						Op:   "mkdir in root",
+						Path: path,
+						Err:  unix.ELOOP,
+					}
+				}
+
+				if filepath.IsAbs(target) {
+					current = ""
+					dir.Close()
+					if dir, err = openInRootFd(
+						root,
+						rootfs,
+						"/",
+						unix.O_PATH|unix.O_DIRECTORY,
+					); err != nil {
+						return nil, err
+					}
+				}
+
+				parts = append(strings.Split(target, "/"), parts...)
+				continue
+			}
+		}
+		dir.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		current = next
+		dir = nextDir
+	}
+
+	return dir, nil
+}
+
+// CreateInRoot creates an empty file at path inside rootfs, if it doesn't
+// already exist, and returns an O_PATH handle to it.
+func CreateInRoot(
+	rootfs, path string,
+	mode os.FileMode,
+) (*os.File, error) {
+	return createInRoot(rootfs, path, mode, 0)
+}
+
+func createInRoot(
+	rootfs, path string,
+	mode os.FileMode,
+	links int,
+) (*os.File, error) {
+	dir, err := MkdirAllInRoot(rootfs, filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	name := filepath.Base(path)
+
+	// an existing file isn't opened, since opening a fifo blocks and opening
+	// a device can have side effects, and only files and directories are
+	// accepted as mount targets
+	var st unix.Stat_t
+	err = unix.Fstatat(int(dir.Fd()), name, &st, unix.AT_SYMLINK_NOFOLLOW)
+	if errors.Is(err, unix.ENOENT) {
+		fd, err := unix.Openat(
+			int(dir.Fd()),
+			name,
+			unix.O_RDONLY|unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_CLOEXEC,
+			uint32(mode.Perm()),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("create in root (%s): %w", path, err)
+		}
+		unix.Close(fd)
+
+		return OpenInRoot(rootfs, path, unix.O_PATH)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("stat in root (%s): %w", path, err)
+	}
+
+	switch st.Mode & unix.S_IFMT {
+	case unix.S_IFREG, unix.S_IFDIR:
+		return OpenInRoot(rootfs, path, unix.O_PATH)
+	case unix.S_IFLNK:
+		target, linkErr := readlinkat(int(dir.Fd()), name)
+		if linkErr != nil {
+			return nil, fmt.Errorf("readlink in root (%s): %w", path, linkErr)
+		}
+
+		// the target is checked, and created if the symlink is dangling
+		if links >= maxSymlinks {
+			return nil, &os.PathError{
+				Op:   "create in root",
+				Path: path,
+				Err:  unix.ELOOP,
+			}
+		}
+
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+
+		return createInRoot(rootfs, target, mode, links+1)
+	default:
+		return nil, fmt.Errorf(
+			"create in root (%s): not a regular file or directory",
+			path,
+		)
+	}
+}
+
+func readlinkat(dirFd int, name string) (string, error) {
+	buf := make([]byte, unix.PathMax)
+
+	n, err := unix.Readlinkat(dirFd, name, buf)
+	if err != nil {
+		return "", err
+	}
+
+	return string(buf[:n]), nil
+}
+
+// ProcFdPath returns a path for f that can be passed to syscalls taking a
+// path, without being resolved again, e.g. as a mount target.
+func ProcFdPath(f *os.File) string {
+	return fmt.Sprintf("/proc/self/fd/%d", f.Fd())
+}
+
+// secureJoin resolves path inside root in userspace, evaluating symlinks
+// as if root were the root directory.
+func secureJoin(root, path string) (string, error) {
+	var resolved string
+	remaining := filepath.Clean("/" + path)
+	links := 0
+
+	for remaining != "" {
+		var part string
+		part, remaining, _ = strings.Cut(strings.TrimPrefix(remaining, "/"), "/")
+		if remaining != "" {
+			remaining = "/" + remaining
+		}
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			if resolved == "." || resolved == "/" {
+				resolved = ""
+			}
+			continue
+		}
+
+		next := resolved + "/" + part
+
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				resolved = next
+				continue
+			}
+			return "", err
+		}
+
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", &os.PathError{Op: "secure join", Path: path, Err: unix.ELOOP}
+		}
+
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+
+		if filepath.IsAbs(target) {
+			resolved = ""
+		}
+		remaining = target + remaining
+		if !filepath.IsAbs(remaining) {
+			remaining = "/" + remaining
+		}
+	}
+
+	return filepath.Join(root, resolved), nil
+}
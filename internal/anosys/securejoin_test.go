@@ -0,0 +1,66 @@
+package anosys
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestCreateInRoot(t *testing.T) {
+	root := t.TempDir()
+
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(
+		filepath.Join(root, "file"),
+		[]byte("existing"),
+		0644,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("/missing/new", filepath.Join(root, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"created", "a/b/created", "dir", "file", "dangling"} {
+		f, err := CreateInRoot(root, path, 0644)
+		if err != nil {
+			t.Errorf("create %s: %v", path, err)
+			continue
+		}
+		f.Close()
+	}
+
+	if got := readTestFile(t, filepath.Join(root, "file")); got != "existing" {
+		t.Errorf("existing file = %q", got)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "missing", "new")); err != nil {
+		t.Errorf("dangling symlink target not created: %v", err)
+	}
+}
+
+func TestCreateInRootRejectsSpecialFiles(t *testing.T) {
+	root := t.TempDir()
+
+	if err := unix.Mkfifo(filepath.Join(root, "fifo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("fifo", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	// opening the fifo would block, without a writer
+	for _, path := range []string{"fifo", "link"} {
+		if f, err := CreateInRoot(root, path, 0644); err == nil {
+			f.Close()
+			t.Errorf("create %s: expected error", path)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package anosys
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+const selinuxfsMount = "/sys/fs/selinux"
+
+// filesystems that don't support the context mount option
+var unlabelledMountTypes = []string{
+	"bind",
+	"cgroup",
+	"cgroup2",
+	"proc",
+	"sysfs",
+}
+
+func SELinuxEnabled() bool {
+	var st unix.Statfs_t
+	if err := unix.Statfs(selinuxfsMount, &st); err != nil {
+		return false
+	}
+
+	return st.Type == unix.SELINUX_MAGIC
+}
+
+// LabelMounts returns a copy of mounts, with the context option set to the
+// mount label on those that support it.
+func LabelMounts(mounts []specs.Mount, label string) []specs.Mount {
+	labelled := slices.Clone(mounts)
+
+	for i, m := range labelled {
+		if slices.Contains(unlabelledMountTypes, m.Type) ||
+			parseMountOptions(m.Options).isBind() {
+			continue
+		}
+
+		labelled[i].Options = append(
+			slices.Clone(m.Options),
+			"context="+label,
+		)
+	}
+
+	return labelled
+}
+
+// SetExecLabel sets the label the process transitions to on exec. The
+// attributes are per-thread, so the caller should be locked to its thread.
+func SetExecLabel(label string) error {
+	if err := os.WriteFile(
+		"/proc/thread-self/attr/exec",
+		[]byte(label),
+		0644,
+	); err != nil {
+		return fmt.Errorf("write exec label: %w", err)
+	}
+
+	return nil
+}
+
+// SetKeyCreateLabel sets the label of keyrings created by the process.
+func SetKeyCreateLabel(label string) error {
+	if err := os.WriteFile(
+		"/proc/thread-self/attr/keycreate",
+		[]byte(label),
+		0644,
+	); err != nil {
+		return fmt.Errorf("write keycreate label: %w", err)
+	}
+
+	return nil
+}
@@ -1,8 +1,14 @@
 package anosys
 
 import (
-	"os"
+	"errors"
+	"fmt"
+	"maps"
 	"path/filepath"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
 var defaultSymlinks = map[string]string{
@@ -10,20 +16,44 @@ var defaultSymlinks = map[string]string{
 	"/proc/self/fd/0": "dev/stdin",
 	"/proc/self/fd/1": "dev/stdout",
 	"/proc/self/fd/2": "dev/stderr",
-	"pts/ptmx":        "dev/ptmx",
 }
 
-func CreateDefaultSymlinks(rootfs string) error {
-	return createSymlinks(defaultSymlinks, rootfs)
+// CreateDefaultSymlinks creates the symlinks expected in /dev, keeping any
+// entries already in the rootfs. /dev/ptmx is only linked when /dev/pts is a
+// devpts mounted by the runtime, by default or from the spec, rather than
+// e.g. bind mounted.
+func CreateDefaultSymlinks(rootfs string, mounts []specs.Mount) error {
+	symlinks := maps.Clone(defaultSymlinks)
+
+	devpts := true
+	for _, m := range mounts {
+		if filepath.Clean(m.Destination) == "/dev/pts" {
+			devpts = m.Type == "devpts"
+		}
+	}
+
+	if devpts {
+		symlinks["pts/ptmx"] = "dev/ptmx"
+	}
+
+	return createSymlinks(symlinks, rootfs)
 }
 
 func createSymlinks(symlinks map[string]string, rootfs string) error {
 	for src, dest := range symlinks {
-		if err := os.Symlink(
-			src,
-			filepath.Join(rootfs, dest),
-		); err != nil {
-			return err
+		dir, err := MkdirAllInRoot(rootfs, filepath.Dir(dest), 0755)
+		if err != nil {
+			return fmt.Errorf("create symlink dir: %w", err)
+		}
+
+		err = unix.Symlinkat(src, int(dir.Fd()), filepath.Base(dest))
+		dir.Close()
+		if errors.Is(err, unix.EEXIST) {
+			logrus.Debugf("skipping symlink (%s), already exists", dest)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("create symlink (%s): %w", dest, err)
 		}
 	}
 
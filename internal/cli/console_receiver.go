@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/nixpig/anocir/internal/operations"
+	"github.com/spf13/cobra"
+)
+
+func consoleReceiverCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "console-receiver [flags] SOCKET_PATH",
+		Short:   "Receive a container's terminal over a console socket",
+		Example: "  anocir console-receiver /tmp/console.sock",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			socketPath := args[0]
+
+			output, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return err
+			}
+
+			if err := operations.ConsoleReceiver(&operations.ConsoleReceiverOpts{
+				SocketPath: socketPath,
+				Output:     output,
+			}); err != nil {
+				return fmt.Errorf("console receiver: %w", err)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP(
+		"output",
+		"o",
+		"",
+		"File to write terminal output to, instead of the current terminal",
+	)
+
+	return cmd
+}
@@ -1,9 +1,13 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
+	"github.com/docker/go-units"
+	"github.com/nixpig/anocir/internal/container"
+	"github.com/nixpig/anocir/internal/logs"
 	"github.com/nixpig/anocir/internal/operations"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -33,11 +37,29 @@ func createCmd() *cobra.Command {
 				return err
 			}
 
+			persistNamespaces, err := cmd.Flags().GetBool("persist-namespaces")
+			if err != nil {
+				return err
+			}
+
+			preserveFds, err := cmd.Flags().GetInt("preserve-fds")
+			if err != nil {
+				return err
+			}
+
+			stdio, err := stdioOpts(cmd)
+			if err != nil {
+				return err
+			}
+
 			if err := operations.Create(&operations.CreateOpts{
-				ID:            containerID,
-				Bundle:        bundle,
-				ConsoleSocket: consoleSocket,
-				PIDFile:       pidFile,
+				ID:                containerID,
+				Bundle:            bundle,
+				ConsoleSocket:     consoleSocket,
+				PIDFile:           pidFile,
+				PersistNamespaces: persistNamespaces,
+				Stdio:             stdio,
+				PreserveFds:       preserveFds,
 			}); err != nil {
 				logrus.Errorf("create operation failed: %s", err)
 				return fmt.Errorf("create: %w", err)
@@ -51,6 +73,66 @@ func createCmd() *cobra.Command {
 	cmd.Flags().StringP("bundle", "b", cwd, "Path to bundle directory")
 	cmd.Flags().StringP("console-socket", "s", "", "Console socket path")
 	cmd.Flags().StringP("pid-file", "p", "", "File to write container PID to")
+	cmd.Flags().BoolP(
+		"persist-namespaces",
+		"",
+		false,
+		"Bind mount container namespaces into state dir for joining later",
+	)
+	cmd.Flags().IntP(
+		"preserve-fds",
+		"",
+		0,
+		"Number of additional fds, from 3, to pass to the container process",
+	)
+	cmd.Flags().StringP("stdin", "", "", "File or named pipe for stdin")
+	cmd.Flags().StringP("stdout", "", "", "File or named pipe for stdout")
+	cmd.Flags().StringP("stderr", "", "", "File or named pipe for stderr")
+	cmd.Flags().StringP(
+		"log-driver",
+		"",
+		"",
+		"Capture stdout and stderr to a log, read with 'logs' (json-file)",
+	)
+	cmd.Flags().StringP(
+		"log-max-size",
+		"",
+		"10m",
+		"Max size of log before rotating",
+	)
+	cmd.Flags().IntP(
+		"log-max-files",
+		"",
+		logs.DefaultMaxFiles,
+		"Max number of log files",
+	)
 
 	return cmd
 }
+
+func stdioOpts(cmd *cobra.Command) (*container.StdioOpts, error) {
+	stdin, _ := cmd.Flags().GetString("stdin")
+	stdout, _ := cmd.Flags().GetString("stdout")
+	stderr, _ := cmd.Flags().GetString("stderr")
+	logDriver, _ := cmd.Flags().GetString("log-driver")
+	logMaxFiles, _ := cmd.Flags().GetInt("log-max-files")
+
+	size, _ := cmd.Flags().GetString("log-max-size")
+	logMaxSize, err := units.RAMInBytes(size)
+	if err != nil {
+		return nil, fmt.Errorf("parse log max size: %w", err)
+	}
+
+	if logMaxSize < 0 || logMaxFiles < 1 {
+		return nil, errors.New("log max size and files must be positive")
+	}
+
+	return &container.StdioOpts{
+		Stdin:       stdin,
+		Stdout:      stdout,
+		Stderr:      stderr,
+		LogDriver:   logDriver,
+		LogMaxSize:  logMaxSize,
+		LogMaxFiles: logMaxFiles,
+	}, nil
+}
@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/nixpig/anocir/internal/operations"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func loggerCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "logger [flags] CONTAINER_ID",
+		Short:   "Write container output to its log\n\n \033[31m ⚠ FOR INTERNAL USE ONLY - DO NOT RUN DIRECTLY ⚠ \033[0m",
+		Example: "\n -- FOR INTERNAL USE ONLY --",
+		Args:    cobra.ExactArgs(1),
+		Hidden:  true, // this command is only used internally
+		RunE: func(cmd *cobra.Command, args []string) error {
+			containerID := args[0]
+
+			maxSize, _ := cmd.Flags().GetInt64("max-size")
+			maxFiles, _ := cmd.Flags().GetInt("max-files")
+
+			if err := operations.Logger(&operations.LoggerOpts{
+				ID:       containerID,
+				MaxSize:  maxSize,
+				MaxFiles: maxFiles,
+			}); err != nil {
+				logrus.Errorf("logger operation failed: %s", err)
+				return fmt.Errorf("logger: %w", err)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().Int64P("max-size", "", 0, "Max size of log file in bytes")
+	cmd.Flags().IntP("max-files", "", 1, "Max number of log files")
+
+	return cmd
+}
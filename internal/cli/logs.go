@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/nixpig/anocir/internal/operations"
+	"github.com/spf13/cobra"
+)
+
+func logsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "logs [flags] CONTAINER_ID",
+		Short:   "Show logs of a container created with a log driver",
+		Example: "  anocir logs --follow busybox",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			containerID := args[0]
+
+			follow, _ := cmd.Flags().GetBool("follow")
+			timestamps, _ := cmd.Flags().GetBool("timestamps")
+
+			if err := operations.Logs(&operations.LogsOpts{
+				ID:         containerID,
+				Follow:     follow,
+				Timestamps: timestamps,
+				Stdout:     cmd.OutOrStdout(),
+				Stderr:     cmd.ErrOrStderr(),
+			}); err != nil {
+				return fmt.Errorf("logs: %w", err)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolP("follow", "f", false, "Follow log output")
+	cmd.Flags().BoolP("timestamps", "t", false, "Show timestamps")
+
+	return cmd
+}
@@ -18,16 +18,8 @@ func reexecCmd() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			containerID := args[0]
 
-			// TODO: figure out a cleaner way of passing the console socket fd
-			var consoleSocketFD *int
-			if cmd.Flags().Changed("console-socket-fd") {
-				flag, _ := cmd.Flags().GetInt("console-socket-fd")
-				consoleSocketFD = &flag
-			}
-
 			if err := operations.Reexec(&operations.ReexecOpts{
-				ID:              containerID,
-				ConsoleSocketFD: consoleSocketFD,
+				ID: containerID,
 			}); err != nil {
 				logrus.Errorf("reexec operation failed: %s", err)
 				return fmt.Errorf("reexec: %w", err)
@@ -37,7 +29,5 @@ func reexecCmd() *cobra.Command {
 		},
 	}
 
-	cmd.Flags().IntP("console-socket-fd", "", 0, "console socket fd")
-
 	return cmd
 }
@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/nixpig/anocir/internal/operations"
+	"github.com/spf13/cobra"
+)
+
+func resizeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "resize [flags] CONTAINER_ID",
+		Short:   "Resize the terminal of a container",
+		Example: "  anocir resize busybox --rows 40 --cols 120",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			containerID := args[0]
+
+			rows, _ := cmd.Flags().GetUint16("rows")
+			cols, _ := cmd.Flags().GetUint16("cols")
+
+			if err := operations.Resize(&operations.ResizeOpts{
+				ID:   containerID,
+				Rows: rows,
+				Cols: cols,
+			}); err != nil {
+				return fmt.Errorf("resize: %w", err)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().Uint16P("rows", "r", 0, "Height of terminal in rows")
+	cmd.Flags().Uint16P("cols", "c", 0, "Width of terminal in columns")
+	cmd.MarkFlagRequired("rows")
+	cmd.MarkFlagRequired("cols")
+
+	return cmd
+}
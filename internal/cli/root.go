@@ -59,6 +59,11 @@ func RootCmd() *cobra.Command {
 		killCmd(),
 		reexecCmd(),
 		featuresCmd(),
+		statsCmd(),
+		resizeCmd(),
+		consoleReceiverCmd(),
+		loggerCmd(),
+		logsCmd(),
 	)
 
 	// TODO: implement for Docker?
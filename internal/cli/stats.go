@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/nixpig/anocir/internal/anosys"
+	"github.com/nixpig/anocir/internal/operations"
+	"github.com/spf13/cobra"
+)
+
+func statsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "stats [flags] CONTAINER_ID",
+		Short:   "Show pressure stall information of a container",
+		Example: "  anocir stats busybox --format table",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			containerID := args[0]
+
+			format, _ := cmd.Flags().GetString("format")
+			if format != "json" && format != "table" {
+				return fmt.Errorf("unknown format: %s", format)
+			}
+
+			stats, err := operations.GetStats(&operations.StatsOpts{
+				ID: containerID,
+			})
+			if err != nil {
+				return err
+			}
+
+			if format == "table" {
+				if err := writeStatsTable(cmd.OutOrStdout(), stats); err != nil {
+					return fmt.Errorf("write stats to stdout: %w", err)
+				}
+				return nil
+			}
+
+			b, err := json.Marshal(stats)
+			if err != nil {
+				return fmt.Errorf("marshal stats: %w", err)
+			}
+
+			if _, err := cmd.OutOrStdout().Write(b); err != nil {
+				return fmt.Errorf("write stats to stdout: %w", err)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP("format", "f", "json", "Output format (json, table)")
+
+	return cmd
+}
+
+func writeStatsTable(out io.Writer, stats *operations.Stats) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "RESOURCE\tTYPE\tAVG10\tAVG60\tAVG300\tTOTAL")
+
+	for _, r := range []struct {
+		name string
+		psi  *anosys.PSIStats
+	}{
+		{"cpu", stats.Pressure.CPU},
+		{"memory", stats.Pressure.Memory},
+		{"io", stats.Pressure.IO},
+	} {
+		if r.psi == nil {
+			continue
+		}
+
+		for _, d := range []struct {
+			kind string
+			data *anosys.PSIData
+		}{
+			{"some", r.psi.Some},
+			{"full", r.psi.Full},
+		} {
+			if d.data == nil {
+				continue
+			}
+
+			fmt.Fprintf(
+				w,
+				"%s\t%s\t%.2f\t%.2f\t%.2f\t%d\n",
+				r.name,
+				d.kind,
+				d.data.Avg10,
+				d.data.Avg60,
+				d.data.Avg300,
+				d.data.Total,
+			)
+		}
+	}
+
+	return w.Flush()
+}
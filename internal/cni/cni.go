@@ -0,0 +1,204 @@
+package cni
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+const (
+	DefaultConfigPath = "/etc/anocir/cni.conflist"
+	DefaultBinDir     = "/opt/cni/bin"
+	DefaultIfName     = "eth0"
+)
+
+type Network struct {
+	ContainerID string
+	ConfigPath  string
+	BinDir      string
+	IfName      string
+	NetNS       string
+}
+
+type configList struct {
+	CNIVersion string                   `json:"cniVersion"`
+	Name       string                   `json:"name"`
+	Plugins    []map[string]interface{} `json:"plugins"`
+}
+
+type pluginError struct {
+	Code    int    `json:"code"`
+	Msg     string `json:"msg"`
+	Details string `json:"details,omitempty"`
+}
+
+func Add(n *Network) (json.RawMessage, error) {
+	list, err := loadConfigList(n.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var result json.RawMessage
+	for i, plugin := range list.Plugins {
+		next, err := n.exec("ADD", list, plugin, result)
+		if err != nil {
+			// release anything the plugins already added, e.g. IPAM
+			// allocations, since there's no result to delete them later;
+			// the failed plugin may have partially added, so it's included
+			return nil, errors.Join(err, n.del(list, list.Plugins[:i+1], result))
+		}
+		result = next
+	}
+
+	return result, nil
+}
+
+func Del(n *Network, prevResult json.RawMessage) error {
+	list, err := loadConfigList(n.ConfigPath)
+	if err != nil {
+		return err
+	}
+
+	return n.del(list, list.Plugins, prevResult)
+}
+
+// del runs DEL on plugins in reverse order, continuing on errors.
+func (n *Network) del(
+	list *configList,
+	plugins []map[string]interface{},
+	prevResult json.RawMessage,
+) error {
+	var errs []error
+	for i := len(plugins) - 1; i >= 0; i-- {
+		if _, err := n.exec(
+			"DEL",
+			list,
+			plugins[i],
+			prevResult,
+		); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (n *Network) exec(
+	command string,
+	list *configList,
+	plugin map[string]interface{},
+	prevResult json.RawMessage,
+) (json.RawMessage, error) {
+	pluginType, ok := plugin["type"].(string)
+	if !ok || pluginType == "" {
+		return nil, errors.New("plugin config missing type")
+	}
+
+	conf := make(map[string]interface{}, len(plugin)+3)
+	for k, v := range plugin {
+		conf[k] = v
+	}
+	conf["cniVersion"] = list.CNIVersion
+	conf["name"] = list.Name
+	if prevResult != nil {
+		conf["prevResult"] = prevResult
+	}
+
+	stdin, err := json.Marshal(conf)
+	if err != nil {
+		return nil, fmt.Errorf("marshal plugin config (%s): %w", pluginType, err)
+	}
+
+	bin, err := n.findPlugin(pluginType)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(bin)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(
+		os.Environ(),
+		"CNI_COMMAND="+command,
+		"CNI_CONTAINERID="+n.ContainerID,
+		"CNI_NETNS="+n.NetNS,
+		"CNI_IFNAME="+n.IfName,
+		"CNI_PATH="+n.BinDir,
+	)
+
+	if err := cmd.Run(); err != nil {
+		var perr pluginError
+		if jsonErr := json.Unmarshal(
+			stdout.Bytes(),
+			&perr,
+		); jsonErr == nil && perr.Msg != "" {
+			return nil, fmt.Errorf(
+				"%s plugin %s (code %d): %s %s",
+				command,
+				pluginType,
+				perr.Code,
+				perr.Msg,
+				perr.Details,
+			)
+		}
+
+		return nil, fmt.Errorf(
+			"%s plugin %s: %w: %s",
+			command,
+			pluginType,
+			err,
+			stderr.String(),
+		)
+	}
+
+	if command == "DEL" || stdout.Len() == 0 {
+		return prevResult, nil
+	}
+
+	return json.RawMessage(stdout.Bytes()), nil
+}
+
+func (n *Network) findPlugin(pluginType string) (string, error) {
+	for _, dir := range filepath.SplitList(n.BinDir) {
+		bin := filepath.Join(dir, pluginType)
+		if _, err := os.Stat(bin); err == nil {
+			return bin, nil
+		}
+	}
+
+	return "", fmt.Errorf("find plugin %s in %s", pluginType, n.BinDir)
+}
+
+func loadConfigList(path string) (*configList, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read network config: %w", err)
+	}
+
+	var list configList
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("unmarshal network config: %w", err)
+	}
+
+	// a single plugin config (.conf) rather than a list (.conflist)
+	if len(list.Plugins) == 0 {
+		var plugin map[string]interface{}
+		if err := json.Unmarshal(b, &plugin); err != nil {
+			return nil, fmt.Errorf("unmarshal network config: %w", err)
+		}
+		list.Plugins = append(list.Plugins, plugin)
+	}
+
+	if list.Name == "" {
+		return nil, errors.New("network config missing name")
+	}
+
+	return &list, nil
+}
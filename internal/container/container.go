@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/exec"
@@ -17,6 +18,7 @@ import (
 
 	"github.com/nixpig/anocir/internal/anosys"
 	"github.com/nixpig/anocir/internal/hooks"
+	"github.com/nixpig/anocir/internal/nsenter"
 	"github.com/nixpig/anocir/internal/terminal"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sirupsen/logrus"
@@ -27,23 +29,38 @@ const (
 	containerRootDir      = "/var/lib/anocir/containers"
 	initSockFilename      = "init.sock"
 	containerSockFilename = "container.sock"
+	namespacesDirname     = "ns"
+
+	mountFdsEnv = "_ANOCIR_MOUNT_FDS"
+
+	// selinuxfs isn't mounted in the container, so whether selinux is
+	// enabled is detected on the host and passed to the reexec process
+	selinuxEnv = "_ANOCIR_SELINUX"
+
+	// the console socket is the first of the reexec process's extra files
+	// after any preserved fds
+	consoleSocketFd = 3
+
+	persistNamespacesAnnotation = "anocir.namespaces.persist"
 )
 
 type Container struct {
-	State           *specs.State
-	Spec            *specs.Spec
-	ConsoleSocket   string
-	ConsoleSocketFD *int
-	PIDFile         string
-	Opts            *NewContainerOpts
-}
-
-type NewContainerOpts struct {
-	ID            string
-	Bundle        string
+	State         *specs.State
 	Spec          *specs.Spec
 	ConsoleSocket string
 	PIDFile       string
+	Opts          *NewContainerOpts
+}
+
+type NewContainerOpts struct {
+	ID                string
+	Bundle            string
+	Spec              *specs.Spec
+	ConsoleSocket     string
+	PIDFile           string
+	PersistNamespaces bool
+	Stdio             *StdioOpts
+	PreserveFds       int
 }
 
 func New(opts *NewContainerOpts) (*Container, error) {
@@ -83,12 +100,39 @@ func (c *Container) Save() error {
 		return fmt.Errorf("serialise container state: %w", err)
 	}
 
-	if err := os.WriteFile(
+	// written to a temp file and renamed, so a process killed while saving,
+	// e.g. the reexec process on a failed create, can't leave it truncated
+	f, err := os.CreateTemp(
+		filepath.Join(containerRootDir, c.State.ID),
+		"state.json.*",
+	)
+	if err != nil {
+		return fmt.Errorf("create container state file: %w", err)
+	}
+
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("set container state file mode: %w", err)
+	}
+
+	if _, err := f.Write(state); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("write container state: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("close container state file: %w", err)
+	}
+
+	if err := os.Rename(
+		f.Name(),
 		filepath.Join(containerRootDir, c.State.ID, "state.json"),
-		state,
-		0666,
 	); err != nil {
-		return fmt.Errorf("write container state: %w", err)
+		os.Remove(f.Name())
+		return fmt.Errorf("rename container state file: %w", err)
 	}
 
 	if c.PIDFile != "" {
@@ -108,7 +152,58 @@ func (c *Container) Save() error {
 	return nil
 }
 
-func (c *Container) Init() error {
+func (c *Container) Init() (err error) {
+	if c.Spec.Process != nil &&
+		c.Spec.Process.ApparmorProfile != "" &&
+		!anosys.AppArmorEnabled() {
+		return fmt.Errorf(
+			"apparmor profile (%s) requested, but apparmor is not enabled",
+			c.Spec.Process.ApparmorProfile,
+		)
+	}
+
+	// checked before the container process is started, rather than when
+	// the rules are applied
+	if _, err := c.landlockRules(); err != nil {
+		return fmt.Errorf("landlock rules: %w", err)
+	}
+
+	// labels are only applied when selinux is enabled, so bundles that always
+	// have them still run on hosts without it
+	selinuxEnabled := anosys.SELinuxEnabled()
+
+	useTerminal := c.Spec.Process != nil && c.Spec.Process.Terminal
+
+	if useTerminal && c.ConsoleSocket == "" {
+		return errors.New("console socket is required when terminal is set")
+	}
+
+	preservedFiles, listenFds, err := c.preservedFiles()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, f := range preservedFiles {
+			f.Close()
+		}
+	}()
+
+	if c.usesOverlayRootfs() {
+		if err := c.mountOverlayRootfs(); err != nil {
+			return fmt.Errorf("mount overlay rootfs: %w", err)
+		}
+
+		defer func() {
+			if err == nil {
+				return
+			}
+
+			if unmountErr := c.unmountOverlayRootfs(); unmountErr != nil {
+				logrus.Warnf("failed to unmount overlay rootfs: %s", unmountErr)
+			}
+		}()
+	}
+
 	if c.Spec.Hooks != nil {
 		if err := hooks.ExecHooks(
 			c.Spec.Hooks.CreateRuntime, c.State,
@@ -125,20 +220,26 @@ func (c *Container) Init() error {
 		}
 	}
 
-	useTerminal := c.Spec.Process != nil &&
-		c.Spec.Process.Terminal &&
-		c.ConsoleSocket != ""
-
+	var consoleSocket *os.File
 	if useTerminal {
 		var err error
-		if c.ConsoleSocketFD, err = terminal.Setup(
-			c.rootFS(),
+		if consoleSocket, err = terminal.ConnectConsoleSocket(
 			c.ConsoleSocket,
 		); err != nil {
 			return err
 		}
+		defer consoleSocket.Close()
 	}
 
+	// the logger is started before any fds meant only for the reexec process
+	// are opened, since it outlives the container process and would
+	// otherwise hold them open
+	stdio, err := c.setupStdio()
+	if err != nil {
+		return fmt.Errorf("setup stdio: %w", err)
+	}
+	defer stdio.Close()
+
 	args := []string{"reexec"}
 
 	logLevel := logrus.GetLevel()
@@ -146,15 +247,29 @@ func (c *Container) Init() error {
 		args = append(args, "--debug")
 	}
 
-	if c.ConsoleSocketFD != nil {
-		fd := strconv.Itoa(*c.ConsoleSocketFD)
-		args = append(args, "--console-socket-fd", fd)
-	}
-
 	args = append(args, c.State.ID)
 
 	cmd := exec.Command("/proc/self/exe", args...)
 
+	// preserved fds keep their numbers in the container process, from 3
+	cmd.ExtraFiles = append(cmd.ExtraFiles, preservedFiles...)
+	cmd.Env = append(
+		cmd.Env,
+		fmt.Sprintf("%s=%d", preserveFdsEnv, len(preservedFiles)),
+	)
+
+	if listenFds > 0 {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("LISTEN_FDS=%d", listenFds))
+	}
+
+	if selinuxEnabled {
+		cmd.Env = append(cmd.Env, selinuxEnv+"=1")
+	}
+
+	if consoleSocket != nil {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, consoleSocket)
+	}
+
 	listener, err := net.Listen(
 		"unix",
 		filepath.Join(containerRootDir, c.State.ID, initSockFilename),
@@ -177,8 +292,25 @@ func (c *Container) Init() error {
 	var uidMappings []syscall.SysProcIDMap
 	var gidMappings []syscall.SysProcIDMap
 
-	for _, ns := range c.Spec.Linux.Namespaces {
-		if ns.Type == specs.UserNamespace {
+	var nsFds []int
+	defer func() {
+		for _, fd := range nsFds {
+			syscall.Close(fd)
+		}
+	}()
+
+	joinsUserNamespace := false
+	joinsPIDNamespace := false
+
+	// join the user namespace first, for the privileges it grants over the
+	// others, and the mount namespace last, since it changes the root
+	namespaces := slices.Clone(c.Spec.Linux.Namespaces)
+	slices.SortStableFunc(namespaces, func(a, b specs.LinuxNamespace) int {
+		return namespaceJoinOrder(a.Type) - namespaceJoinOrder(b.Type)
+	})
+
+	for _, ns := range namespaces {
+		if ns.Type == specs.UserNamespace && ns.Path == "" {
 			uidMappings = append(uidMappings, syscall.SysProcIDMap{
 				ContainerID: 0,
 				HostID:      os.Getuid(),
@@ -204,43 +336,185 @@ func (c *Container) Init() error {
 
 		if ns.Path == "" {
 			cloneFlags |= anosys.NamespaceFlags[ns.Type]
-		} else {
-			suffix := fmt.Sprintf(
-				"/%s",
-				anosys.NamespaceEnvs[ns.Type],
-			)
-			if !strings.HasSuffix(ns.Path, suffix) &&
-				ns.Type != specs.PIDNamespace {
+			continue
+		}
+
+		// not close-on-exec, so the reexec process inherits it to join
+		fd, err := syscall.Open(ns.Path, syscall.O_RDONLY, 0)
+		if err != nil {
+			// pid_for_children can't be opened until its init process is forked
+			if errors.Is(err, unix.ENOENT) &&
+				filepath.Base(ns.Path) == "pid_for_children" {
 				return fmt.Errorf(
-					"namespace type (%s) and path (%s) do not match",
-					ns.Type,
+					"pid namespace (%s) has no init process",
 					ns.Path,
 				)
 			}
+			return fmt.Errorf("open namespace path (%s): %w", ns.Path, err)
+		}
+		nsFds = append(nsFds, fd)
 
-			if ns.Type == specs.MountNamespace {
-				// mount namespaces do not work across threads, so this needs to be done
-				// in single-threaded context in C before the reexec
-				gonsEnv := fmt.Sprintf(
-					"gons_%s=%s",
-					anosys.NamespaceEnvs[ns.Type],
-					ns.Path,
-				)
-				cmd.Env = append(cmd.Env, gonsEnv)
-			} else {
-				fd, err := syscall.Open(ns.Path, syscall.O_RDONLY, 0666)
-				if err != nil {
-					return fmt.Errorf("open ns path: %w", err)
-				}
+		nsType, err := anosys.NamespaceType(fd)
+		if err != nil {
+			return fmt.Errorf("namespace path (%s): %w", ns.Path, err)
+		}
 
-				_, _, errno := syscall.Syscall(unix.SYS_SETNS, uintptr(fd), 0, 0)
-				if errno != 0 {
-					return fmt.Errorf("errno: %w", errno)
-				}
+		if nsType != anosys.NamespaceFlags[ns.Type] {
+			return fmt.Errorf(
+				"namespace type (%s) and path (%s) do not match",
+				ns.Type,
+				ns.Path,
+			)
+		}
 
-				syscall.Close(fd)
+		switch ns.Type {
+		case specs.UserNamespace:
+			joinsUserNamespace = true
+		case specs.PIDNamespace:
+			if err := anosys.ValidatePIDNamespace(ns.Path, fd); err != nil {
+				return fmt.Errorf("validate pid namespace (%s): %w", ns.Path, err)
 			}
+			joinsPIDNamespace = true
+		}
+	}
+
+	if len(nsFds) > 0 {
+		fds := make([]string, len(nsFds))
+		for i, fd := range nsFds {
+			fds[i] = strconv.Itoa(fd)
 		}
+
+		cmd.Env = append(
+			cmd.Env,
+			fmt.Sprintf("%s=%s", nsenter.FdsEnv, strings.Join(fds, ",")),
+		)
+	}
+
+	// the cgroup namespace is created by the reexec process once it's been
+	// added to its cgroups, so that they're the root of the namespace
+	newCgroupNamespace := cloneFlags&unix.CLONE_NEWCGROUP != 0
+	cloneFlags &^= unix.CLONE_NEWCGROUP
+
+	// joining namespaces by path needs privileges over them, which a process
+	// cloned into a new user namespace doesn't have, so the user namespace is
+	// created after joining them instead, and mapped once it's created
+	unsharesUserNamespace := cloneFlags&unix.CLONE_NEWUSER != 0 &&
+		len(nsFds) > 0
+
+	// namespaces created on clone would be owned by the runtime's user
+	// namespace, so create them after joining or creating the user namespace
+	// instead; the pid namespace only applies to children, so the reexec
+	// process forks into it, and the time namespace is still cloned
+	unshareFlags := uintptr(0)
+	if joinsUserNamespace || unsharesUserNamespace {
+		unshareFlags = cloneFlags &^ unix.CLONE_NEWTIME
+	}
+	cloneFlags &^= unshareFlags
+
+	if unshareFlags != 0 {
+		cmd.Env = append(
+			cmd.Env,
+			fmt.Sprintf("%s=%d", nsenter.UnshareEnv, unshareFlags),
+		)
+	}
+
+	// the reexec process waits on this socket until its user namespace has
+	// been mapped
+	var usernsSync []int
+	if unsharesUserNamespace {
+		fds, err := syscall.Socketpair(
+			syscall.AF_UNIX,
+			syscall.SOCK_STREAM,
+			0,
+		)
+		if err != nil {
+			return fmt.Errorf("create user namespace sync socket: %w", err)
+		}
+		syscall.CloseOnExec(fds[0])
+		usernsSync = fds[:]
+
+		cmd.Env = append(
+			cmd.Env,
+			fmt.Sprintf("%s=%d", nsenter.UsernsSyncEnv, usernsSync[1]),
+		)
+	}
+
+	// the reexec process forks into the joined or unshared pid namespace, and
+	// the pid of the child, i.e. the container process, is read back from
+	// this pipe
+	var pidPipe []int
+	if joinsPIDNamespace || unshareFlags&unix.CLONE_NEWPID != 0 {
+		pidPipe = make([]int, 2)
+		if err := syscall.Pipe(pidPipe); err != nil {
+			return fmt.Errorf("create pid pipe: %w", err)
+		}
+		syscall.CloseOnExec(pidPipe[0])
+
+		cmd.Env = append(
+			cmd.Env,
+			fmt.Sprintf("%s=%d", nsenter.PIDPipeEnv, pidPipe[1]),
+		)
+	}
+
+	// the reexec process waits on this pipe until it's been added to its
+	// cgroups, before creating its cgroup namespace
+	var cgroupPipe []int
+	if newCgroupNamespace {
+		cgroupPipe = make([]int, 2)
+		if err := syscall.Pipe(cgroupPipe); err != nil {
+			return fmt.Errorf("create cgroup sync pipe: %w", err)
+		}
+		syscall.CloseOnExec(cgroupPipe[1])
+
+		cmd.Env = append(
+			cmd.Env,
+			fmt.Sprintf("%s=%d", nsenter.CgroupSyncEnv, cgroupPipe[0]),
+		)
+	}
+
+	// idmapped mounts need privileges in the runtime's user namespace, so are
+	// prepared here and attached by the reexec process in its mount namespace
+	var mountFds []int
+	defer func() {
+		for _, fd := range mountFds {
+			syscall.Close(fd)
+		}
+	}()
+
+	var mountFdsEnvValue []string
+	for i, m := range c.Spec.Mounts {
+		if !anosys.IsIDMappedMount(m) {
+			continue
+		}
+
+		// without its own mappings, the mount is mapped like the container
+		if len(m.UIDMappings) == 0 {
+			m.UIDMappings = containerIDMappings(
+				c.Spec.Linux.UIDMappings,
+				uidMappings,
+			)
+		}
+		if len(m.GIDMappings) == 0 {
+			m.GIDMappings = containerIDMappings(
+				c.Spec.Linux.GIDMappings,
+				gidMappings,
+			)
+		}
+
+		fd, err := anosys.PrepareIDMappedMount(m)
+		if err != nil {
+			return fmt.Errorf("prepare idmapped mount: %w", err)
+		}
+		mountFds = append(mountFds, fd)
+
+		mountFdsEnvValue = append(mountFdsEnvValue, fmt.Sprintf("%d=%d", i, fd))
+	}
+
+	if len(mountFdsEnvValue) > 0 {
+		cmd.Env = append(
+			cmd.Env,
+			fmt.Sprintf("%s=%s", mountFdsEnv, strings.Join(mountFdsEnvValue, ",")),
+		)
 	}
 
 	// FIXME: needed to run 'linux_uid_mappings'
@@ -260,25 +534,94 @@ func (c *Container) Init() error {
 	// 	})
 	// }
 
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Cloneflags:  cloneFlags,
-		UidMappings: uidMappings,
-		GidMappings: gidMappings,
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: cloneFlags}
+	if !unsharesUserNamespace {
+		cmd.SysProcAttr.UidMappings = uidMappings
+		cmd.SysProcAttr.GidMappings = gidMappings
 	}
 
 	if c.Spec.Process != nil && c.Spec.Process.Env != nil {
 		cmd.Env = append(cmd.Env, c.Spec.Process.Env...)
 	}
 
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdin = stdio.stdin
+	cmd.Stdout = stdio.stdout
+	cmd.Stderr = stdio.stderr
 
 	if err := cmd.Start(); err != nil {
+		if pidPipe != nil {
+			syscall.Close(pidPipe[0])
+			syscall.Close(pidPipe[1])
+		}
+		if cgroupPipe != nil {
+			syscall.Close(cgroupPipe[0])
+			syscall.Close(cgroupPipe[1])
+		}
+		if usernsSync != nil {
+			syscall.Close(usernsSync[0])
+			syscall.Close(usernsSync[1])
+		}
 		return fmt.Errorf("reexec container process: %w", err)
 	}
 
+	if cgroupPipe != nil {
+		syscall.Close(cgroupPipe[0])
+	}
+
+	if usernsSync != nil {
+		syscall.Close(usernsSync[1])
+	}
+
+	// so output pipes are closed once the container process exits
+	stdio.Close()
+
+	for _, fd := range nsFds {
+		syscall.Close(fd)
+	}
+	nsFds = nil
+
+	for _, fd := range mountFds {
+		syscall.Close(fd)
+	}
+	mountFds = nil
+
 	c.State.Pid = cmd.Process.Pid
+
+	defer func() {
+		if err != nil {
+			c.cleanupFailedInit()
+		}
+	}()
+
+	if usernsSync != nil {
+		err := mapUserNamespace(
+			usernsSync[0],
+			cmd.Process.Pid,
+			uidMappings,
+			gidMappings,
+		)
+		syscall.Close(usernsSync[0])
+		if err != nil {
+			return fmt.Errorf("map user namespace: %w", err)
+		}
+	}
+
+	if pidPipe != nil {
+		syscall.Close(pidPipe[1])
+
+		pidReader := os.NewFile(uintptr(pidPipe[0]), "pid-pipe")
+		pid, err := readForkedPID(pidReader)
+		pidReader.Close()
+		if err != nil {
+			return fmt.Errorf("read container pid: %w", err)
+		}
+
+		if err := cmd.Wait(); err != nil {
+			return fmt.Errorf("wait for reexec process to fork: %w", err)
+		}
+
+		c.State.Pid = pid
+	}
 	if err := c.Save(); err != nil {
 		return fmt.Errorf("save container pid state: %w", err)
 	}
@@ -292,6 +635,14 @@ func (c *Container) Init() error {
 			); err != nil {
 				return err
 			}
+
+			if err := anosys.SetV2DeviceRules(
+				c.State.Pid,
+				c.Spec.Linux.Resources.Devices,
+				c.Spec.Linux.Devices,
+			); err != nil {
+				return fmt.Errorf("set device rules: %w", err)
+			}
 		} else if c.Spec.Linux.CgroupsPath != "" {
 			if err := anosys.AddV1CGroups(
 				c.Spec.Linux.CgroupsPath,
@@ -303,6 +654,22 @@ func (c *Container) Init() error {
 		}
 	}
 
+	if err := c.addResctrlGroup(); err != nil {
+		return fmt.Errorf("add resctrl group: %w", err)
+	}
+
+	if cgroupPipe != nil {
+		_, err := syscall.Write(cgroupPipe[1], []byte{0})
+		syscall.Close(cgroupPipe[1])
+		if err != nil {
+			return fmt.Errorf("signal cgroups added: %w", err)
+		}
+	}
+
+	if err := c.setupNetwork(); err != nil {
+		return fmt.Errorf("setup network: %w", err)
+	}
+
 	if err := cmd.Process.Release(); err != nil {
 		logrus.Errorf("failed to release container process: %s", err)
 		return fmt.Errorf("release container process: %w", err)
@@ -325,6 +692,27 @@ func (c *Container) Init() error {
 		return fmt.Errorf("expecting 'ready' but received '%s'", msg)
 	}
 
+	// persisted once ready, since the cgroup namespace is only created by
+	// the reexec process after it's been added to its cgroups
+	if c.Opts.PersistNamespaces ||
+		c.Spec.Annotations[persistNamespacesAnnotation] == "true" {
+		var types []specs.LinuxNamespaceType
+		for _, ns := range c.Spec.Linux.Namespaces {
+			if ns.Path == "" {
+				types = append(types, ns.Type)
+			}
+		}
+
+		if err := anosys.PersistNamespaces(
+			c.State.Pid,
+			types,
+			filepath.Join(containerRootDir, c.State.ID, namespacesDirname),
+		); err != nil {
+			// any namespaces already bound are unmounted on cleanup
+			return fmt.Errorf("persist namespaces: %w", err)
+		}
+	}
+
 	c.State.Status = specs.StateCreated
 	if err := c.Save(); err != nil {
 		return fmt.Errorf("save created state: %w", err)
@@ -337,36 +725,21 @@ func (c *Container) Reexec() error {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
-	var pty *terminal.Pty
-	if c.ConsoleSocketFD != nil {
-		var err error
-
-		pty, err = terminal.NewPty()
-		if err != nil {
-			return fmt.Errorf("new pty: %w", err)
-		}
-
-		if c.Spec.Process.ConsoleSize != nil {
-			unix.IoctlSetWinsize(
-				int(pty.Slave.Fd()),
-				unix.TIOCSWINSZ,
-				&unix.Winsize{
-					Row: uint16(c.Spec.Process.ConsoleSize.Height),
-					Col: uint16(c.Spec.Process.ConsoleSize.Width),
-				},
-			)
-		}
+	preservedFds, err := strconv.Atoi(os.Getenv(preserveFdsEnv))
+	if err != nil {
+		return fmt.Errorf("parse preserved fds: %w", err)
+	}
+	os.Unsetenv(preserveFdsEnv)
 
-		if err := terminal.SendPty(
-			*c.ConsoleSocketFD,
-			pty,
-		); err != nil {
-			return fmt.Errorf("connect pty and socket: %w", err)
-		}
+	selinuxEnabled := os.Getenv(selinuxEnv) == "1"
+	os.Unsetenv(selinuxEnv)
 
-		if err := pty.Connect(); err != nil {
-			return fmt.Errorf("connect pty: %w", err)
-		}
+	var consoleSocket *os.File
+	if c.Spec.Process != nil && c.Spec.Process.Terminal {
+		fd := consoleSocketFd + preservedFds
+		consoleSocket = os.NewFile(uintptr(fd), "console-socket")
+		syscall.CloseOnExec(fd)
+		defer consoleSocket.Close()
 	}
 
 	if err := anosys.MountRootfs(c.rootFS()); err != nil {
@@ -377,10 +750,38 @@ func (c *Container) Reexec() error {
 		return fmt.Errorf("mount proc: %w", err)
 	}
 
-	if err := anosys.MountSpecMounts(c.Spec.Mounts, c.rootFS()); err != nil {
+	mountFds, err := parseMountFds(os.Getenv(mountFdsEnv))
+	if err != nil {
+		return fmt.Errorf("parse mount fds: %w", err)
+	}
+	os.Unsetenv(mountFdsEnv)
+
+	var mountLabel string
+	if selinuxEnabled {
+		mountLabel = c.Spec.Linux.MountLabel
+	}
+
+	mounts := c.Spec.Mounts
+	if mountLabel != "" {
+		mounts = anosys.LabelMounts(mounts, mountLabel)
+	}
+
+	if err := anosys.MountSpecMounts(
+		mounts,
+		c.rootFS(),
+		mountFds,
+	); err != nil {
 		return fmt.Errorf("mount spec: %w", err)
 	}
 
+	if err := anosys.MountDefaultDevMounts(
+		c.rootFS(),
+		c.Spec.Mounts,
+		mountLabel,
+	); err != nil {
+		return fmt.Errorf("mount default dev mounts: %w", err)
+	}
+
 	if err := anosys.MountDefaultDevices(c.rootFS()); err != nil {
 		return fmt.Errorf("mount default devices: %w", err)
 	}
@@ -392,14 +793,29 @@ func (c *Container) Reexec() error {
 		return fmt.Errorf("mount devices from spec: %w", err)
 	}
 
-	if err := anosys.CreateDefaultSymlinks(c.rootFS()); err != nil {
+	if err := anosys.CreateDefaultSymlinks(
+		c.rootFS(),
+		c.Spec.Mounts,
+	); err != nil {
 		return fmt.Errorf("create default symlinks: %w", err)
 	}
 
-	if c.ConsoleSocketFD != nil && c.Spec.Process.Terminal {
-		target := filepath.Join(c.rootFS(), "dev/console")
-		if err := pty.MountSlave(target); err != nil {
-			return err
+	hasNewNetworkNamespace := slices.ContainsFunc(
+		c.Spec.Linux.Namespaces,
+		func(n specs.LinuxNamespace) bool {
+			return n.Type == specs.NetworkNamespace && n.Path == ""
+		},
+	)
+
+	if hasNewNetworkNamespace {
+		if err := anosys.SetupLoopback(); err != nil {
+			return fmt.Errorf("setup loopback: %w", err)
+		}
+	}
+
+	if consoleSocket != nil {
+		if err := c.setupTerminal(consoleSocket); err != nil {
+			return fmt.Errorf("setup terminal: %w", err)
 		}
 	}
 
@@ -531,6 +947,11 @@ func (c *Container) Reexec() error {
 		}
 	}
 
+	landlockRules, err := c.landlockRules()
+	if err != nil {
+		return fmt.Errorf("landlock rules: %w", err)
+	}
+
 	if c.Spec.Process.Scheduler != nil {
 		if err := anosys.SetSchedAttrs(c.Spec.Process.Scheduler); err != nil {
 			return fmt.Errorf("set sched attrs: %w", err)
@@ -543,6 +964,12 @@ func (c *Container) Reexec() error {
 		}
 	}
 
+	if c.Spec.Linux.Personality != nil {
+		if err := anosys.SetPersonality(c.Spec.Linux.Personality); err != nil {
+			return err
+		}
+	}
+
 	if err := anosys.SetUser(&c.Spec.Process.User); err != nil {
 		return fmt.Errorf("set user: %w", err)
 	}
@@ -564,9 +991,42 @@ func (c *Container) Reexec() error {
 		return fmt.Errorf("find path of user process binary: %w", err)
 	}
 
+	// socket activation fds are for this process, which becomes the
+	// container process on exec
+	if os.Getenv("LISTEN_FDS") != "" {
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	}
+
 	args := c.Spec.Process.Args
 	env := os.Environ()
 
+	if c.Spec.Process.SelinuxLabel != "" && selinuxEnabled {
+		if err := anosys.SetKeyCreateLabel(
+			c.Spec.Process.SelinuxLabel,
+		); err != nil {
+			return fmt.Errorf("set keycreate label: %w", err)
+		}
+
+		if err := anosys.SetExecLabel(c.Spec.Process.SelinuxLabel); err != nil {
+			return fmt.Errorf("set exec label: %w", err)
+		}
+	}
+
+	if c.Spec.Process.ApparmorProfile != "" {
+		if err := anosys.SetAppArmorProfile(
+			c.Spec.Process.ApparmorProfile,
+		); err != nil {
+			return fmt.Errorf("set apparmor profile: %w", err)
+		}
+	}
+
+	// applied last, since it could deny writing the attributes above
+	if landlockRules != nil {
+		if err := anosys.ApplyLandlock(landlockRules); err != nil {
+			return fmt.Errorf("apply landlock: %w", err)
+		}
+	}
+
 	if err := syscall.Exec(bin, args, env); err != nil {
 		return fmt.Errorf("execve (%s, %s, %v): %w", bin, args, env, err)
 	}
@@ -640,6 +1100,10 @@ func (c *Container) Delete(force bool) error {
 		)
 	}
 
+	if err := c.teardownNetwork(); err != nil {
+		logrus.Warnf("failed to teardown network: %s", err)
+	}
+
 	process, err := os.FindProcess(c.State.Pid)
 	if err != nil {
 		return fmt.Errorf("find container process to delete: %w", err)
@@ -648,10 +1112,30 @@ func (c *Container) Delete(force bool) error {
 		process.Signal(unix.SIGKILL)
 	}
 
+	// each step is attempted, even if one fails, so as little as possible
+	// is left behind
+	var errs []error
+
+	if err := anosys.UnpersistNamespaces(
+		filepath.Join(containerRootDir, c.State.ID, namespacesDirname),
+	); err != nil {
+		errs = append(errs, fmt.Errorf("unpersist namespaces: %w", err))
+	}
+
+	if err := c.unmountOverlayRootfs(); err != nil {
+		errs = append(errs, fmt.Errorf("unmount overlay rootfs: %w", err))
+	}
+
+	if err := c.deleteResctrlGroup(); err != nil {
+		errs = append(errs, fmt.Errorf("delete resctrl group: %w", err))
+	}
+
+	c.stopLogger()
+
 	if err := os.RemoveAll(
 		filepath.Join(containerRootDir, c.State.ID),
 	); err != nil {
-		return fmt.Errorf("delete container directory: %w", err)
+		errs = append(errs, fmt.Errorf("delete container directory: %w", err))
 	}
 
 	if c.Spec.Hooks != nil {
@@ -662,7 +1146,41 @@ func (c *Container) Delete(force bool) error {
 		}
 	}
 
-	return nil
+	return errors.Join(errs...)
+}
+
+// cleanupFailedInit undoes what Init set up once the container process was
+// started, so a failed create doesn't leave it running.
+func (c *Container) cleanupFailedInit() {
+	unix.Kill(c.State.Pid, unix.SIGKILL)
+
+	if err := c.teardownNetwork(); err != nil {
+		logrus.Warnf("failed to teardown network: %s", err)
+	}
+
+	if err := anosys.UnpersistNamespaces(
+		filepath.Join(containerRootDir, c.State.ID, namespacesDirname),
+	); err != nil {
+		logrus.Warnf("failed to unpersist namespaces: %s", err)
+	}
+
+	if err := c.deleteResctrlGroup(); err != nil {
+		logrus.Warnf("failed to delete resctrl group: %s", err)
+	}
+
+	if c.Spec.Linux.Resources == nil {
+		return
+	}
+
+	var err error
+	if anosys.IsUnifiedCGroupsMode() {
+		err = anosys.DeleteV2CGroups(c.State.ID)
+	} else if c.Spec.Linux.CgroupsPath != "" {
+		err = anosys.DeleteV1CGroups(c.Spec.Linux.CgroupsPath)
+	}
+	if err != nil {
+		logrus.Warnf("failed to delete cgroups: %s", err)
+	}
 }
 
 func (c *Container) Kill(sig string) error {
@@ -698,7 +1216,123 @@ func (c *Container) Kill(sig string) error {
 	return nil
 }
 
+func parseMountFds(env string) (map[int]int, error) {
+	mountFds := make(map[int]int)
+	if env == "" {
+		return mountFds, nil
+	}
+
+	for _, entry := range strings.Split(env, ",") {
+		index, fd, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid mount fd entry: %s", entry)
+		}
+
+		i, err := strconv.Atoi(index)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mount index (%s): %w", index, err)
+		}
+
+		f, err := strconv.Atoi(fd)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mount fd (%s): %w", fd, err)
+		}
+
+		mountFds[i] = f
+	}
+
+	return mountFds, nil
+}
+
+func readForkedPID(r io.Reader) (int, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(b) == 0 {
+		return 0, errors.New("reexec process exited before forking")
+	}
+
+	return strconv.Atoi(string(b))
+}
+
+// containerIDMappings returns the mappings of the container's user
+// namespace, from the spec, or else those a new user namespace is created
+// with, if any.
+func containerIDMappings(
+	specMappings []specs.LinuxIDMapping,
+	mappings []syscall.SysProcIDMap,
+) []specs.LinuxIDMapping {
+	if len(specMappings) > 0 {
+		return specMappings
+	}
+
+	m := make([]specs.LinuxIDMapping, len(mappings))
+	for i, mapping := range mappings {
+		m[i] = specs.LinuxIDMapping{
+			ContainerID: uint32(mapping.ContainerID),
+			HostID:      uint32(mapping.HostID),
+			Size:        uint32(mapping.Size),
+		}
+	}
+
+	return m
+}
+
+// mapUserNamespace waits for the reexec process to create its user
+// namespace, maps it, then lets the process continue.
+func mapUserNamespace(
+	sync int,
+	pid int,
+	uidMappings []syscall.SysProcIDMap,
+	gidMappings []syscall.SysProcIDMap,
+) error {
+	b := make([]byte, 1)
+	if n, err := syscall.Read(sync, b); err != nil || n != 1 {
+		// the reexec process exited before creating it
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("wait for user namespace: %w", err)
+	}
+
+	if err := anosys.WriteIDMappings(pid, uidMappings, gidMappings); err != nil {
+		return err
+	}
+
+	if _, err := syscall.Write(sync, b); err != nil {
+		return fmt.Errorf("signal user namespace mapped: %w", err)
+	}
+
+	return nil
+}
+
+func namespaceJoinOrder(t specs.LinuxNamespaceType) int {
+	switch t {
+	case specs.UserNamespace:
+		return 0
+	case specs.MountNamespace:
+		return 2
+	default:
+		return 1
+	}
+}
+
 func (c *Container) rootFS() string {
+	if c.usesOverlayRootfs() {
+		return filepath.Join(
+			containerRootDir,
+			c.State.ID,
+			overlayDirname,
+			"merged",
+		)
+	}
+
+	return c.specRootPath()
+}
+
+func (c *Container) specRootPath() string {
 	if strings.HasPrefix(c.Spec.Root.Path, "/") {
 		return c.Spec.Root.Path
 	}
@@ -706,6 +1340,77 @@ func (c *Container) rootFS() string {
 	return filepath.Join(c.State.Bundle, c.Spec.Root.Path)
 }
 
+// setupTerminal allocates a pty from the container's devpts, sends the master
+// over the console socket and connects the slave as the process's stdio and
+// /dev/console. Neither the master nor the socket are kept open, so aren't
+// visible in the container.
+func (c *Container) setupTerminal(consoleSocket *os.File) error {
+	pty, err := terminal.NewPty(c.rootFS())
+	if err != nil {
+		return fmt.Errorf("new pty: %w", err)
+	}
+	defer pty.Slave.Close()
+
+	if c.Spec.Process.ConsoleSize != nil {
+		if err := unix.IoctlSetWinsize(
+			int(pty.Slave.Fd()),
+			unix.TIOCSWINSZ,
+			&unix.Winsize{
+				Row: uint16(c.Spec.Process.ConsoleSize.Height),
+				Col: uint16(c.Spec.Process.ConsoleSize.Width),
+			},
+		); err != nil {
+			pty.Master.Close()
+			return fmt.Errorf("set console size: %w", err)
+		}
+	}
+
+	err = terminal.SendPty(consoleSocket, pty)
+	pty.Master.Close()
+	consoleSocket.Close()
+	if err != nil {
+		return fmt.Errorf("send pty to console socket: %w", err)
+	}
+
+	if err := pty.MountSlave(c.rootFS(), "/dev/console"); err != nil {
+		return err
+	}
+
+	if err := pty.Connect(); err != nil {
+		return fmt.Errorf("connect pty: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Container) Resize(rows, cols uint16) error {
+	if !c.canBeResized() {
+		return fmt.Errorf(
+			"container cannot be resized in current state (%s)",
+			c.State.Status,
+		)
+	}
+
+	if c.Spec.Process == nil || !c.Spec.Process.Terminal {
+		return errors.New("container process doesn't have a terminal")
+	}
+
+	if !processRunning(c.State.Pid) {
+		return errors.New("container process isn't running")
+	}
+
+	tty, err := terminal.ControllingTerminal(c.State.Pid)
+	if err != nil {
+		return fmt.Errorf("find terminal: %w", err)
+	}
+
+	if err := terminal.Resize(tty, rows, cols); err != nil {
+		return fmt.Errorf("resize terminal: %w", err)
+	}
+
+	return nil
+}
+
 func (c *Container) canBeDeleted() bool {
 	return c.State.Status == specs.StateStopped
 }
@@ -719,6 +1424,11 @@ func (c *Container) canBeKilled() bool {
 		c.State.Status == specs.StateCreated
 }
 
+func (c *Container) canBeResized() bool {
+	return c.State.Status == specs.StateRunning ||
+		c.State.Status == specs.StateCreated
+}
+
 func Load(id string) (*Container, error) {
 	s, err := os.ReadFile(filepath.Join(containerRootDir, id, "state.json"))
 	if err != nil {
@@ -0,0 +1,67 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	preserveFdsEnv = "_ANOCIR_PRESERVE_FDS"
+
+	// fds after stdio, where systemd passes socket activation fds
+	listenFdsStart = 3
+)
+
+// preservedFiles returns the fds passed to the runtime to pass on to the
+// container process, i.e. socket activation fds, if the runtime was socket
+// activated, followed by the number to preserve, and the number of socket
+// activation fds.
+func (c *Container) preservedFiles() ([]*os.File, int, error) {
+	listenFds := 0
+	if pid := os.Getenv("LISTEN_PID"); pid == "" ||
+		pid == strconv.Itoa(os.Getpid()) {
+		if n := os.Getenv("LISTEN_FDS"); n != "" {
+			var err error
+			if listenFds, err = strconv.Atoi(n); err != nil || listenFds < 0 {
+				return nil, 0, fmt.Errorf("invalid LISTEN_FDS: %s", n)
+			}
+		}
+	}
+
+	preserveFds := 0
+	if c.Opts != nil {
+		preserveFds = c.Opts.PreserveFds
+	}
+
+	if preserveFds < 0 {
+		return nil, 0, fmt.Errorf("invalid preserve fds: %d", preserveFds)
+	}
+
+	end := listenFdsStart + listenFds + preserveFds
+
+	// checked before any are wrapped, so an error doesn't close fds the
+	// runtime is using
+	for fd := listenFdsStart; fd < end; fd++ {
+		if _, err := unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0); err != nil {
+			return nil, 0, fmt.Errorf("preserved fd (%d) isn't open: %w", fd, err)
+		}
+	}
+
+	var files []*os.File
+
+	for fd := listenFdsStart; fd < end; fd++ {
+		// so it isn't leaked to hooks, etc.; it's passed to the container
+		// process explicitly
+		unix.CloseOnExec(fd)
+
+		files = append(
+			files,
+			os.NewFile(uintptr(fd), "preserved-fd-"+strconv.Itoa(fd)),
+		)
+	}
+
+	return files, listenFds, nil
+}
@@ -0,0 +1,88 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nixpig/anocir/internal/anosys"
+)
+
+const (
+	landlockReadOnlyAnnotation  = "anocir.landlock.ro"
+	landlockReadWriteAnnotation = "anocir.landlock.rw"
+	landlockBindAnnotation      = "anocir.landlock.bind"
+	landlockConnectAnnotation   = "anocir.landlock.connect"
+)
+
+// landlockRules returns the landlock rules configured by annotations, or nil
+// if there are none.
+func (c *Container) landlockRules() (*anosys.LandlockRules, error) {
+	ro, hasRO := c.Spec.Annotations[landlockReadOnlyAnnotation]
+	rw, hasRW := c.Spec.Annotations[landlockReadWriteAnnotation]
+	bind, hasBind := c.Spec.Annotations[landlockBindAnnotation]
+	connect, hasConnect := c.Spec.Annotations[landlockConnectAnnotation]
+
+	if !hasRO && !hasRW && !hasBind && !hasConnect {
+		return nil, nil
+	}
+
+	// landlock can only be applied with no new privileges, which isn't set
+	// when the spec doesn't ask for it
+	if c.Spec.Process == nil || !c.Spec.Process.NoNewPrivileges {
+		return nil, errors.New("landlock requires process.noNewPrivileges")
+	}
+
+	rules := &anosys.LandlockRules{}
+
+	// empty, rather than nil, so that all other filesystem access is denied
+	if hasRO || hasRW {
+		rules.ReadOnlyPaths = append([]string{}, splitAnnotation(ro)...)
+		rules.ReadWritePaths = append([]string{}, splitAnnotation(rw)...)
+	}
+
+	var err error
+
+	if hasBind {
+		if rules.BindPorts, err = parsePorts(bind); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", landlockBindAnnotation, err)
+		}
+	}
+
+	if hasConnect {
+		if rules.ConnectPorts, err = parsePorts(connect); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", landlockConnectAnnotation, err)
+		}
+	}
+
+	return rules, nil
+}
+
+func splitAnnotation(value string) []string {
+	var values []string
+
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
+func parsePorts(value string) ([]uint16, error) {
+	// empty, rather than nil, so that all ports are denied
+	ports := []uint16{}
+
+	for _, p := range splitAnnotation(value) {
+		port, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port (%s): %w", p, err)
+		}
+
+		ports = append(ports, uint16(port))
+	}
+
+	return ports, nil
+}
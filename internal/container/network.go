@@ -0,0 +1,154 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/nixpig/anocir/internal/cni"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	cniConfigAnnotation = "anocir.cni.config"
+	cniBinDirAnnotation = "anocir.cni.bin"
+	cniIfNameAnnotation = "anocir.cni.ifname"
+
+	// the network state is recorded in the container state's annotations,
+	// so it's reported by the state command along with the rest
+	cniStateAnnotation = "anocir.cni.state"
+)
+
+// networkState is what's needed to delete the network. NetNSInode identifies
+// the namespace, since the pid in NetNS could be reused.
+type networkState struct {
+	ConfigPath string          `json:"configPath"`
+	BinDir     string          `json:"binDir"`
+	IfName     string          `json:"ifName"`
+	NetNS      string          `json:"netns"`
+	NetNSInode uint64          `json:"netnsInode"`
+	Result     json.RawMessage `json:"result,omitempty"`
+}
+
+func (c *Container) cniNetwork() *cni.Network {
+	hasNewNetworkNamespace := slices.ContainsFunc(
+		c.Spec.Linux.Namespaces,
+		func(n specs.LinuxNamespace) bool {
+			return n.Type == specs.NetworkNamespace && n.Path == ""
+		},
+	)
+	if !hasNewNetworkNamespace {
+		return nil
+	}
+
+	network := &cni.Network{
+		ContainerID: c.State.ID,
+		ConfigPath:  c.Spec.Annotations[cniConfigAnnotation],
+		BinDir:      c.Spec.Annotations[cniBinDirAnnotation],
+		IfName:      c.Spec.Annotations[cniIfNameAnnotation],
+		NetNS:       fmt.Sprintf("/proc/%d/ns/net", c.State.Pid),
+	}
+
+	if network.ConfigPath == "" {
+		if _, err := os.Stat(cni.DefaultConfigPath); err != nil {
+			return nil
+		}
+		network.ConfigPath = cni.DefaultConfigPath
+	}
+
+	if network.BinDir == "" {
+		network.BinDir = cni.DefaultBinDir
+	}
+
+	if network.IfName == "" {
+		network.IfName = cni.DefaultIfName
+	}
+
+	return network
+}
+
+func (c *Container) setupNetwork() error {
+	network := c.cniNetwork()
+	if network == nil {
+		return nil
+	}
+
+	inode, err := netNSInode(network.NetNS)
+	if err != nil {
+		return fmt.Errorf("stat network namespace: %w", err)
+	}
+
+	result, err := cni.Add(network)
+	if err != nil {
+		return fmt.Errorf("add cni network: %w", err)
+	}
+
+	state, err := json.Marshal(&networkState{
+		ConfigPath: network.ConfigPath,
+		BinDir:     network.BinDir,
+		IfName:     network.IfName,
+		NetNS:      network.NetNS,
+		NetNSInode: inode,
+		Result:     result,
+	})
+	if err != nil {
+		cni.Del(network, result)
+		return fmt.Errorf("serialise network state: %w", err)
+	}
+
+	// the state's annotations are shared with the spec's, so copy them
+	// rather than adding to the spec
+	c.State.Annotations = maps.Clone(c.State.Annotations)
+	if c.State.Annotations == nil {
+		c.State.Annotations = map[string]string{}
+	}
+	// saved along with the created state, after the reexec process is ready,
+	// since it saves the state it loaded when it starts
+	c.State.Annotations[cniStateAnnotation] = string(state)
+
+	return nil
+}
+
+func (c *Container) teardownNetwork() error {
+	b, ok := c.State.Annotations[cniStateAnnotation]
+	if !ok {
+		return nil
+	}
+
+	var state networkState
+	if err := json.Unmarshal([]byte(b), &state); err != nil {
+		return fmt.Errorf("unmarshal network state: %w", err)
+	}
+
+	// the network namespace is gone along with the container process, but
+	// plugins still need to release any resources, e.g. IPAM allocations;
+	// if the pid has been reused, the path is to an unrelated namespace
+	netns := state.NetNS
+	if inode, err := netNSInode(netns); err != nil || inode != state.NetNSInode {
+		netns = ""
+	}
+
+	if err := cni.Del(&cni.Network{
+		ContainerID: c.State.ID,
+		ConfigPath:  state.ConfigPath,
+		BinDir:      state.BinDir,
+		IfName:      state.IfName,
+		NetNS:       netns,
+	}, state.Result); err != nil {
+		return fmt.Errorf("delete cni network: %w", err)
+	}
+
+	return nil
+}
+
+func netNSInode(path string) (uint64, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return 0, err
+	}
+
+	return st.Ino, nil
+}
@@ -0,0 +1,82 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nixpig/anocir/internal/anosys"
+)
+
+const (
+	overlayDirname = "overlay"
+
+	rootfsLayersAnnotation = "anocir.rootfs.layers"
+)
+
+// layersDescriptor is read from root.path, when it's a file rather than a
+// directory.
+type layersDescriptor struct {
+	// topmost first, relative to the bundle unless absolute
+	Layers []string `json:"layers"`
+}
+
+// usesOverlayRootfs returns whether the rootfs is assembled from layers,
+// listed in an annotation or a layers descriptor at root.path.
+func (c *Container) usesOverlayRootfs() bool {
+	if c.Spec.Annotations[rootfsLayersAnnotation] != "" {
+		return true
+	}
+
+	fi, err := os.Stat(c.specRootPath())
+
+	return err == nil && fi.Mode().IsRegular()
+}
+
+func (c *Container) rootfsLayers() ([]string, error) {
+	var layers []string
+
+	if annotation := c.Spec.Annotations[rootfsLayersAnnotation]; annotation != "" {
+		layers = strings.Split(annotation, ":")
+	} else {
+		b, err := os.ReadFile(c.specRootPath())
+		if err != nil {
+			return nil, fmt.Errorf("read layers descriptor: %w", err)
+		}
+
+		var descriptor layersDescriptor
+		if err := json.Unmarshal(b, &descriptor); err != nil {
+			return nil, fmt.Errorf("parse layers descriptor: %w", err)
+		}
+
+		layers = descriptor.Layers
+	}
+
+	for i, l := range layers {
+		if !filepath.IsAbs(l) {
+			layers[i] = filepath.Join(c.State.Bundle, l)
+		}
+	}
+
+	return layers, nil
+}
+
+func (c *Container) mountOverlayRootfs() error {
+	layers, err := c.rootfsLayers()
+	if err != nil {
+		return err
+	}
+
+	return anosys.MountOverlayRootfs(
+		layers,
+		filepath.Join(containerRootDir, c.State.ID, overlayDirname),
+	)
+}
+
+func (c *Container) unmountOverlayRootfs() error {
+	return anosys.UnmountOverlayRootfs(
+		filepath.Join(containerRootDir, c.State.ID, overlayDirname),
+	)
+}
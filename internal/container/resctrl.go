@@ -0,0 +1,60 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nixpig/anocir/internal/anosys"
+)
+
+// resctrlFilename records the CLOS group created for the container, so
+// it's only removed on delete if it wasn't pre-existing.
+const resctrlFilename = "resctrl"
+
+func (c *Container) addResctrlGroup() error {
+	if c.Spec.Linux == nil || c.Spec.Linux.IntelRdt == nil {
+		return nil
+	}
+
+	closID := anosys.ResctrlClosID(c.State.ID, c.Spec.Linux.IntelRdt)
+
+	created, err := anosys.AddResctrlGroup(
+		closID,
+		c.Spec.Linux.IntelRdt,
+		c.State.Pid,
+	)
+	if err != nil {
+		return err
+	}
+
+	if !created {
+		return nil
+	}
+
+	if err := os.WriteFile(
+		filepath.Join(containerRootDir, c.State.ID, resctrlFilename),
+		[]byte(closID),
+		0644,
+	); err != nil {
+		anosys.DeleteResctrlGroup(closID)
+		return fmt.Errorf("save clos group: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Container) deleteResctrlGroup() error {
+	closID, err := os.ReadFile(
+		filepath.Join(containerRootDir, c.State.ID, resctrlFilename),
+	)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read clos group: %w", err)
+	}
+
+	return anosys.DeleteResctrlGroup(string(closID))
+}
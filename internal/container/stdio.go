@@ -0,0 +1,247 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/nixpig/anocir/internal/logs"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	LogDriverJSONFile = "json-file"
+
+	logFilename       = "container.log"
+	loggerPIDFilename = "logger.pid"
+)
+
+type StdioOpts struct {
+	// paths of files or named pipes; if not set, the runtime's own stdio is
+	// inherited
+	Stdin  string
+	Stdout string
+	Stderr string
+	// captures stdout and stderr to a log in the state dir instead
+	LogDriver   string
+	LogMaxSize  int64
+	LogMaxFiles int
+}
+
+// stdio is the container process's stdio, and the runtime's copies of the
+// files, to close once the container process has started.
+type stdio struct {
+	stdin  *os.File
+	stdout *os.File
+	stderr *os.File
+	files  []*os.File
+}
+
+func (s *stdio) Close() {
+	for _, f := range s.files {
+		f.Close()
+	}
+	s.files = nil
+}
+
+func LogPath(id string) string {
+	return filepath.Join(containerRootDir, id, logFilename)
+}
+
+func (c *Container) setupStdio() (*stdio, error) {
+	s := &stdio{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}
+
+	opts := c.Opts.Stdio
+	if opts == nil {
+		return s, nil
+	}
+
+	if c.Spec.Process != nil && c.Spec.Process.Terminal {
+		if opts.Stdin != "" ||
+			opts.Stdout != "" ||
+			opts.Stderr != "" ||
+			opts.LogDriver != "" {
+			return nil, errors.New("stdio can't be set when terminal is set")
+		}
+		return s, nil
+	}
+
+	if opts.Stdin != "" {
+		f, err := openStdio(opts.Stdin, os.O_RDONLY)
+		if err != nil {
+			return nil, fmt.Errorf("open stdin: %w", err)
+		}
+		s.stdin = f
+		s.files = append(s.files, f)
+	}
+
+	switch opts.LogDriver {
+	case "":
+	case LogDriverJSONFile:
+		if opts.Stdout != "" || opts.Stderr != "" {
+			s.Close()
+			return nil, errors.New(
+				"stdout and stderr can't be set with a log driver",
+			)
+		}
+
+		// the container is detached from the runtime's stdio
+		if opts.Stdin == "" {
+			s.stdin = nil
+		}
+
+		if err := c.startLogger(s); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("start logger: %w", err)
+		}
+
+		return s, nil
+	default:
+		s.Close()
+		return nil, fmt.Errorf("unknown log driver: %s", opts.LogDriver)
+	}
+
+	for _, o := range []struct {
+		path string
+		dest **os.File
+	}{
+		{opts.Stdout, &s.stdout},
+		{opts.Stderr, &s.stderr},
+	} {
+		if o.path == "" {
+			continue
+		}
+
+		f, err := openStdio(o.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("open %s: %w", o.path, err)
+		}
+		*o.dest = f
+		s.files = append(s.files, f)
+	}
+
+	return s, nil
+}
+
+// openStdio opens path for the container's stdio. Output named pipes are
+// opened read-write, so opening doesn't block until there's a reader.
+// Input named pipes block until there's a writer, so the container still
+// gets EOF when it closes.
+func openStdio(path string, flags int) (*os.File, error) {
+	if fi, err := os.Stat(path); err == nil &&
+		fi.Mode()&os.ModeNamedPipe != 0 &&
+		flags&os.O_WRONLY != 0 {
+		flags = os.O_RDWR
+	}
+
+	return os.OpenFile(path, flags, 0644)
+}
+
+// startLogger starts a detached logger process, which outlives the runtime,
+// to write the container's stdout and stderr to the log.
+func (c *Container) startLogger(s *stdio) error {
+	opts := c.Opts.Stdio
+
+	maxSize := opts.LogMaxSize
+	if maxSize == 0 {
+		maxSize = logs.DefaultMaxSize
+	}
+
+	maxFiles := opts.LogMaxFiles
+	if maxFiles == 0 {
+		maxFiles = logs.DefaultMaxFiles
+	}
+
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("create stdout pipe: %w", err)
+	}
+	defer stdoutR.Close()
+
+	stderrR, stderrW, err := os.Pipe()
+	if err != nil {
+		stdoutW.Close()
+		return fmt.Errorf("create stderr pipe: %w", err)
+	}
+	defer stderrR.Close()
+
+	s.stdout = stdoutW
+	s.stderr = stderrW
+	s.files = append(s.files, stdoutW, stderrW)
+
+	cmd := exec.Command(
+		"/proc/self/exe",
+		"logger",
+		"--max-size", strconv.FormatInt(maxSize, 10),
+		"--max-files", strconv.Itoa(maxFiles),
+		c.State.ID,
+	)
+	// stdout and stderr pipes are fds 3 and 4
+	cmd.ExtraFiles = []*os.File{stdoutR, stderrR}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start logger process: %w", err)
+	}
+
+	if err := os.WriteFile(
+		filepath.Join(containerRootDir, c.State.ID, loggerPIDFilename),
+		[]byte(strconv.Itoa(cmd.Process.Pid)),
+		0644,
+	); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("save logger pid: %w", err)
+	}
+
+	return cmd.Process.Release()
+}
+
+func loggerPID(id string) (int, error) {
+	b, err := os.ReadFile(
+		filepath.Join(containerRootDir, id, loggerPIDFilename),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(string(b))
+}
+
+// LoggerRunning returns whether the container's logger process is still
+// writing to the log.
+func LoggerRunning(id string) bool {
+	pid, err := loggerPID(id)
+	if err != nil {
+		return false
+	}
+
+	return processRunning(pid)
+}
+
+// processRunning returns whether pid exists and hasn't exited, since an
+// exited process may not have been reaped yet.
+func processRunning(pid int) bool {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+
+	_, fields, ok := strings.Cut(string(b), ") ")
+
+	return ok && !strings.HasPrefix(fields, "Z") && !strings.HasPrefix(fields, "X")
+}
+
+// stopLogger kills the logger process, in case the container's stdout or
+// stderr are still held open, e.g. by a daemonised process.
+func (c *Container) stopLogger() {
+	if pid, err := loggerPID(c.State.ID); err == nil {
+		unix.Kill(pid, unix.SIGKILL)
+	}
+}
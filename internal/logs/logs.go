@@ -0,0 +1,283 @@
+package logs
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxSize  = 10 * 1024 * 1024
+	DefaultMaxFiles = 3
+
+	followInterval = 250 * time.Millisecond
+)
+
+// Entry is a line of output, in the same format as Docker's json-file
+// driver.
+type Entry struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+// Writer writes entries as JSON lines to path, rotating to path.1, path.2,
+// etc. once it exceeds max size, and keeping at most max files in total.
+type Writer struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	f        *os.File
+	size     int64
+}
+
+func NewWriter(path string, maxSize int64, maxFiles int) (*Writer, error) {
+	if maxFiles < 1 {
+		return nil, fmt.Errorf("invalid max log files: %d", maxFiles)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("open log file: %w", err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("stat log file: %w", err)
+	}
+
+	return &Writer{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		f:        f,
+		size:     fi.Size(),
+	}, nil
+}
+
+func (w *Writer) Write(stream string, line []byte) error {
+	b, err := json.Marshal(Entry{
+		Log:    string(line),
+		Stream: stream,
+		Time:   time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("marshal log entry: %w", err)
+	}
+	b = append(b, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(b)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.f.Write(b)
+	w.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("write log entry: %w", err)
+	}
+
+	return nil
+}
+
+func (w *Writer) rotate() error {
+	if err := w.f.Close(); err != nil {
+		return fmt.Errorf("close log file: %w", err)
+	}
+
+	if w.maxFiles == 1 {
+		if err := os.Remove(w.path); err != nil {
+			return fmt.Errorf("remove log file: %w", err)
+		}
+	}
+
+	for i := w.maxFiles - 1; i > 0; i-- {
+		src := w.path
+		if i > 1 {
+			src = rotatedPath(w.path, i-1)
+		}
+
+		if err := os.Rename(
+			src,
+			rotatedPath(w.path, i),
+		); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("rotate log file: %w", err)
+		}
+	}
+
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+
+	w.f = f
+	w.size = 0
+
+	return nil
+}
+
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.f.Close()
+}
+
+// Copy writes each line read from r as an entry for stream, until EOF.
+func Copy(w *Writer, stream string, r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			if err := w.Write(stream, line); err != nil {
+				return err
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read %s: %w", stream, err)
+		}
+	}
+}
+
+func rotatedPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// Read calls fn with each entry at path, oldest first, including rotated
+// files. If follow is set, it keeps reading new entries until done returns
+// true.
+func Read(
+	path string,
+	follow bool,
+	done func() bool,
+	fn func(Entry) error,
+) error {
+	rotations := 0
+	for {
+		if _, err := os.Stat(rotatedPath(path, rotations+1)); err != nil {
+			break
+		}
+		rotations++
+	}
+
+	for i := rotations; i > 0; i-- {
+		if err := readFile(rotatedPath(path, i), fn); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	defer func() { f.Close() }()
+
+	for {
+		if err := readEntries(f, fn); err != nil {
+			return err
+		}
+
+		if !follow {
+			return nil
+		}
+
+		// checked before the rotation and final read, so nothing written
+		// before the writer finished is missed
+		finished := done()
+
+		// the writer has rotated, so continue from the start of the new file,
+		// after anything remaining in the old one
+		if rotated(f, path) {
+			next, err := os.Open(path)
+			if err != nil {
+				return fmt.Errorf("open log file: %w", err)
+			}
+
+			if err := readEntries(f, fn); err != nil {
+				next.Close()
+				return err
+			}
+
+			f.Close()
+			f = next
+			continue
+		}
+
+		if finished {
+			return readEntries(f, fn)
+		}
+
+		time.Sleep(followInterval)
+	}
+}
+
+func readFile(path string, fn func(Entry) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	defer f.Close()
+
+	return readEntries(f, fn)
+}
+
+// readEntries reads complete lines from f until EOF, leaving f positioned
+// at the start of any partially written line, so it's read again once
+// complete.
+func readEntries(f *os.File, fn func(Entry) error) error {
+	br := bufio.NewReader(f)
+
+	for {
+		line, err := br.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				if _, err := f.Seek(-int64(len(line)), io.SeekCurrent); err != nil {
+					return fmt.Errorf("seek log file: %w", err)
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read log file: %w", err)
+		}
+
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return fmt.Errorf("parse log entry: %w", err)
+		}
+
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
+
+func rotated(f *os.File, path string) bool {
+	current, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	latest, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	return !os.SameFile(current, latest)
+}
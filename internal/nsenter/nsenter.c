@@ -0,0 +1,131 @@
+#define _GNU_SOURCE
+#include <errno.h>
+#include <sched.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <unistd.h>
+
+char *nsenter_msg = NULL;
+
+static char nsenter_buf[256];
+
+static void nsenter_error(const char *action, const char *detail) {
+	snprintf(nsenter_buf, sizeof(nsenter_buf), "%s (%s): %s", action, detail, strerror(errno));
+	nsenter_msg = nsenter_buf;
+}
+
+// nsenter joins the namespaces referenced by the fds in _ANOCIR_NSENTER_FDS,
+// in the order given, then unshares any flags in _ANOCIR_NSENTER_UNSHARE. It
+// runs as a constructor, before the Go runtime starts any threads, since
+// joining user and mount namespaces requires a single-threaded process.
+//
+// If _ANOCIR_NSENTER_USERNS_SYNC is set, the unshared flags include a new user
+// namespace, so it writes to the socket and waits for the runtime to reply,
+// once the namespace has been mapped.
+//
+// Joining a pid namespace only applies to children, so if
+// _ANOCIR_NSENTER_PID_PIPE is set, it forks, writes the pid of the child to
+// the pipe, and exits, leaving the child to continue as the container.
+//
+// If _ANOCIR_NSENTER_CGROUP_SYNC is set, it waits for the runtime to write to
+// the pipe, once the container has been added to its cgroups, then unshares
+// the cgroup namespace, so that they're its root.
+void nsenter(void) {
+	char *fds = getenv("_ANOCIR_NSENTER_FDS");
+	if (fds != NULL && *fds != '\0') {
+		char *s = strdup(fds);
+		if (s == NULL) {
+			nsenter_error("copy namespace fds", fds);
+			return;
+		}
+
+		char *saveptr = NULL;
+		for (char *tok = strtok_r(s, ",", &saveptr); tok != NULL; tok = strtok_r(NULL, ",", &saveptr)) {
+			int fd = atoi(tok);
+
+			if (setns(fd, 0) == -1) {
+				nsenter_error("join namespace", tok);
+				free(s);
+				return;
+			}
+
+			close(fd);
+		}
+
+		free(s);
+	}
+
+	char *flags = getenv("_ANOCIR_NSENTER_UNSHARE");
+	if (flags != NULL && *flags != '\0') {
+		if (unshare(atoi(flags)) == -1) {
+			nsenter_error("unshare namespaces", flags);
+			return;
+		}
+	}
+
+	char *userns = getenv("_ANOCIR_NSENTER_USERNS_SYNC");
+	if (userns != NULL && *userns != '\0') {
+		int fd = atoi(userns);
+		char c = 0;
+
+		if (write(fd, &c, 1) != 1) {
+			nsenter_error("signal user namespace created", userns);
+			return;
+		}
+
+		ssize_t n = read(fd, &c, 1);
+		if (n != 1) {
+			// the runtime exited without mapping the user namespace
+			if (n == 0) {
+				errno = EPIPE;
+			}
+			nsenter_error("wait for user namespace mappings", userns);
+			return;
+		}
+
+		close(fd);
+	}
+
+	char *pipe = getenv("_ANOCIR_NSENTER_PID_PIPE");
+	if (pipe != NULL && *pipe != '\0') {
+		int fd = atoi(pipe);
+
+		pid_t pid = fork();
+		if (pid == -1) {
+			// the kernel refuses to fork into a pid namespace whose init has exited
+			nsenter_error("fork into pid namespace", errno == ENOMEM ? "no init process" : pipe);
+			return;
+		}
+
+		if (pid > 0) {
+			dprintf(fd, "%d", pid);
+			_exit(0);
+		}
+
+		close(fd);
+	}
+
+	char *sync = getenv("_ANOCIR_NSENTER_CGROUP_SYNC");
+	if (sync != NULL && *sync != '\0') {
+		int fd = atoi(sync);
+		char c;
+
+		ssize_t n = read(fd, &c, 1);
+		if (n != 1) {
+			// the runtime exited without adding the container to its cgroups
+			if (n == 0) {
+				errno = EPIPE;
+			}
+			nsenter_error("wait for cgroups", sync);
+			return;
+		}
+
+		close(fd);
+
+		if (unshare(CLONE_NEWCGROUP) == -1) {
+			nsenter_error("unshare cgroup namespace", sync);
+			return;
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package nsenter
+
+/*
+extern void nsenter(void);
+extern char *nsenter_msg;
+void __attribute__((constructor)) init(void) {
+	nsenter();
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"os"
+)
+
+const (
+	FdsEnv        = "_ANOCIR_NSENTER_FDS"
+	UnshareEnv    = "_ANOCIR_NSENTER_UNSHARE"
+	PIDPipeEnv    = "_ANOCIR_NSENTER_PID_PIPE"
+	CgroupSyncEnv = "_ANOCIR_NSENTER_CGROUP_SYNC"
+	UsernsSyncEnv = "_ANOCIR_NSENTER_USERNS_SYNC"
+)
+
+func init() {
+	// these are only for the constructor; don't leak them into the container
+	os.Unsetenv(FdsEnv)
+	os.Unsetenv(UnshareEnv)
+	os.Unsetenv(PIDPipeEnv)
+	os.Unsetenv(CgroupSyncEnv)
+	os.Unsetenv(UsernsSyncEnv)
+}
+
+func Status() error {
+	if C.nsenter_msg == nil {
+		return nil
+	}
+
+	return errors.New(C.GoString(C.nsenter_msg))
+}
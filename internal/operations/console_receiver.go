@@ -0,0 +1,79 @@
+package operations
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/nixpig/anocir/internal/terminal"
+)
+
+type ConsoleReceiverOpts struct {
+	SocketPath string
+	// file to write output to, instead of proxying the current terminal
+	Output string
+}
+
+// ConsoleReceiver listens on the console socket for a container's pty master
+// and proxies it until the container exits.
+func ConsoleReceiver(opts *ConsoleReceiverOpts) error {
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{
+		Name: opts.SocketPath,
+		Net:  "unix",
+	})
+	if err != nil {
+		return fmt.Errorf("listen on console socket: %w", err)
+	}
+	defer listener.Close()
+
+	conn, err := listener.AcceptUnix()
+	if err != nil {
+		return fmt.Errorf("accept on console socket: %w", err)
+	}
+
+	master, err := terminal.RecvPty(conn)
+	conn.Close()
+	if err != nil {
+		return fmt.Errorf("receive pty: %w", err)
+	}
+	defer master.Close()
+
+	if opts.Output != "" {
+		f, err := os.OpenFile(
+			opts.Output,
+			os.O_CREATE|os.O_WRONLY|os.O_APPEND,
+			0644,
+		)
+		if err != nil {
+			return fmt.Errorf("open output file: %w", err)
+		}
+		defer f.Close()
+
+		if err := terminal.CopyFromPty(f, master); err != nil {
+			return fmt.Errorf("copy from pty: %w", err)
+		}
+
+		return nil
+	}
+
+	stdin := int(os.Stdin.Fd())
+	if terminal.IsTerminal(stdin) {
+		restore, err := terminal.MakeRaw(stdin)
+		if err != nil {
+			return fmt.Errorf("make terminal raw: %w", err)
+		}
+		defer restore()
+
+		stop := terminal.ForwardResize(stdin, master)
+		defer stop()
+	}
+
+	go io.Copy(master, os.Stdin)
+
+	if err := terminal.CopyFromPty(os.Stdout, master); err != nil {
+		return fmt.Errorf("copy from pty: %w", err)
+	}
+
+	return nil
+}
@@ -11,10 +11,13 @@ import (
 )
 
 type CreateOpts struct {
-	ID            string
-	Bundle        string
-	ConsoleSocket string
-	PIDFile       string
+	ID                string
+	Bundle            string
+	ConsoleSocket     string
+	PIDFile           string
+	PersistNamespaces bool
+	Stdio             *container.StdioOpts
+	PreserveFds       int
 }
 
 func Create(opts *CreateOpts) error {
@@ -38,11 +41,14 @@ func Create(opts *CreateOpts) error {
 	}
 
 	cntr, err := container.New(&container.NewContainerOpts{
-		ID:            opts.ID,
-		Bundle:        bundle,
-		Spec:          spec,
-		ConsoleSocket: opts.ConsoleSocket,
-		PIDFile:       opts.PIDFile,
+		ID:                opts.ID,
+		Bundle:            bundle,
+		Spec:              spec,
+		ConsoleSocket:     opts.ConsoleSocket,
+		PIDFile:           opts.PIDFile,
+		PersistNamespaces: opts.PersistNamespaces,
+		Stdio:             opts.Stdio,
+		PreserveFds:       opts.PreserveFds,
 	})
 	if err != nil {
 		return fmt.Errorf("create container: %w", err)
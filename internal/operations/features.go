@@ -1,6 +1,9 @@
 package operations
 
-import "github.com/opencontainers/runtime-spec/specs-go"
+import (
+	"github.com/nixpig/anocir/internal/anosys"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
 
 func GetFeatures() *Features {
 	return &Features{
@@ -23,6 +26,7 @@ func GetFeatures() *Features {
 			"diratime",
 			"dirsync",
 			"exec",
+			"idmap",
 			"iversion",
 			"lazytime",
 			"loud",
@@ -36,14 +40,34 @@ func GetFeatures() *Features {
 			"nostrictatime",
 			"nosuid",
 			"nosymfollow",
+			"notmpcopyup",
 			"private",
+			"ratime",
 			"rbind",
+			"rdev",
+			"rdiratime",
 			"relatime",
 			"remount",
+			"rexec",
+			"ridmap",
+			"rnoatime",
+			"rnodev",
+			"rnodiratime",
+			"rnoexec",
+			"rnorelatime",
+			"rnostrictatime",
+			"rnosuid",
+			"rnosymfollow",
 			"ro",
 			"rprivate",
+			"rrelatime",
+			"rro",
+			"rrw",
 			"rshared",
 			"rslave",
+			"rstrictatime",
+			"rsuid",
+			"rsymfollow",
 			"runbindable",
 			"rw",
 			"shared",
@@ -52,6 +76,7 @@ func GetFeatures() *Features {
 			"strictatime",
 			"suid",
 			"sync",
+			"tmpcopyup",
 			"unbindable",
 		},
 		Linux: &LinuxFeatures{
@@ -107,25 +132,34 @@ func GetFeatures() *Features {
 				"CAP_SYSLOG",
 				"CAP_WAKE_ALARM",
 			},
+			// cgroup v2 is managed through systemd, on the system bus
 			CGroup: &CGroupFeatures{
 				V1:          true,
-				V2:          false,
-				Systemd:     true,
+				V2:          anosys.IsUnifiedCGroupsMode(),
+				Systemd:     anosys.IsUnifiedCGroupsMode(),
 				SystemdUser: false,
-				RDMA:        false,
+				RDMA:        anosys.V2CGroupControllerEnabled("rdma"),
 			},
 			Seccomp: &SeccompFeatures{
 				Enabled: false,
 			},
 			AppArmor: &AppArmorFeatures{
-				Enabled: false,
+				Enabled: anosys.AppArmorEnabled(),
 			},
 			SELinux: &SELinuxFeatures{
-				Enabled: false,
+				Enabled: anosys.SELinuxEnabled(),
 			},
 			IntelRDT: &IntelRDTFeatures{
-				Enabled: false,
+				Enabled: anosys.ResctrlEnabled(),
 			},
+			MountEntensions: &MountExtensionsFeatures{
+				IDMap: &IDMapFeatures{
+					Enabled: anosys.IDMappedMountsSupported(),
+				},
+			},
+		},
+		Annotations: map[string]string{
+			"anocir.personality.domains": "LINUX,LINUX32",
 		},
 	}
 }
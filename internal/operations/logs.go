@@ -0,0 +1,90 @@
+package operations
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/nixpig/anocir/internal/container"
+	"github.com/nixpig/anocir/internal/logs"
+)
+
+type LoggerOpts struct {
+	ID       string
+	MaxSize  int64
+	MaxFiles int
+}
+
+// Logger writes the container's stdout and stderr, read from fds 3 and 4,
+// to its log until both are closed.
+func Logger(opts *LoggerOpts) error {
+	w, err := logs.NewWriter(
+		container.LogPath(opts.ID),
+		opts.MaxSize,
+		opts.MaxFiles,
+	)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	errs := make(chan error, 2)
+
+	for i, stream := range []string{"stdout", "stderr"} {
+		f := os.NewFile(uintptr(3+i), stream)
+
+		go func() {
+			defer f.Close()
+			errs <- logs.Copy(w, stream, f)
+		}()
+	}
+
+	return errors.Join(<-errs, <-errs)
+}
+
+type LogsOpts struct {
+	ID         string
+	Follow     bool
+	Timestamps bool
+	Stdout     io.Writer
+	Stderr     io.Writer
+}
+
+func Logs(opts *LogsOpts) error {
+	if !container.Exists(opts.ID) {
+		return fmt.Errorf("container '%s' doesn't exist", opts.ID)
+	}
+
+	path := container.LogPath(opts.ID)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf(
+			"container '%s' wasn't created with a log driver",
+			opts.ID,
+		)
+	}
+
+	return logs.Read(
+		path,
+		opts.Follow,
+		func() bool { return !container.LoggerRunning(opts.ID) },
+		func(e logs.Entry) error {
+			w := opts.Stdout
+			if e.Stream == "stderr" {
+				w = opts.Stderr
+			}
+
+			line := e.Log
+			if opts.Timestamps {
+				line = e.Time.Format(time.RFC3339Nano) + " " + line
+			}
+
+			if _, err := io.WriteString(w, line); err != nil {
+				return fmt.Errorf("write log: %w", err)
+			}
+
+			return nil
+		},
+	)
+}
@@ -7,8 +7,7 @@ import (
 )
 
 type ReexecOpts struct {
-	ID              string
-	ConsoleSocketFD *int
+	ID string
 }
 
 func Reexec(opts *ReexecOpts) error {
@@ -17,8 +16,6 @@ func Reexec(opts *ReexecOpts) error {
 		return fmt.Errorf("load container: %w", err)
 	}
 
-	cntr.ConsoleSocketFD = opts.ConsoleSocketFD
-
 	if err := cntr.Reexec(); err != nil {
 		return fmt.Errorf("reexec container: %w", err)
 	}
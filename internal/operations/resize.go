@@ -0,0 +1,26 @@
+package operations
+
+import (
+	"fmt"
+
+	"github.com/nixpig/anocir/internal/container"
+)
+
+type ResizeOpts struct {
+	ID   string
+	Rows uint16
+	Cols uint16
+}
+
+func Resize(opts *ResizeOpts) error {
+	cntr, err := container.Load(opts.ID)
+	if err != nil {
+		return fmt.Errorf("load container: %w", err)
+	}
+
+	if err := cntr.Resize(opts.Rows, opts.Cols); err != nil {
+		return fmt.Errorf("resize container: %w", err)
+	}
+
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/nixpig/anocir/internal/anosys"
 	"github.com/nixpig/anocir/internal/container"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"golang.org/x/sys/unix"
@@ -32,7 +33,18 @@ func State(opts *StateOpts) (string, error) {
 		}
 	}
 
-	state, err := json.Marshal(cntr.State)
+	// pressure stall information is reported along with the state, when the
+	// container is running in a cgroup of its own, but its absence isn't an
+	// error, since state is queried regardless
+	var pressure *anosys.PressureStats
+	if cntr.State.Status != specs.StateStopped {
+		pressure, _ = containerPressure(cntr)
+	}
+
+	state, err := json.Marshal(struct {
+		*specs.State
+		Pressure *anosys.PressureStats `json:"pressure,omitempty"`
+	}{cntr.State, pressure})
 	if err != nil {
 		return "", fmt.Errorf("marshal state: %w", err)
 	}
@@ -0,0 +1,56 @@
+package operations
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/nixpig/anocir/internal/anosys"
+	"github.com/nixpig/anocir/internal/container"
+	"golang.org/x/sys/unix"
+)
+
+type StatsOpts struct {
+	ID string
+}
+
+type Stats struct {
+	ID       string                `json:"id"`
+	Pressure *anosys.PressureStats `json:"pressure"`
+}
+
+func GetStats(opts *StatsOpts) (*Stats, error) {
+	cntr, err := container.Load(opts.ID)
+	if err != nil {
+		return nil, fmt.Errorf("load container: %w", err)
+	}
+
+	process, err := os.FindProcess(cntr.State.Pid)
+	if err != nil {
+		return nil, fmt.Errorf("find container process: %w", err)
+	}
+
+	if err := process.Signal(unix.Signal(0)); err != nil {
+		return nil, fmt.Errorf("container is not running (%s)", opts.ID)
+	}
+
+	pressure, err := containerPressure(cntr)
+	if err != nil {
+		return nil, fmt.Errorf("get pressure stats: %w", err)
+	}
+
+	return &Stats{ID: opts.ID, Pressure: pressure}, nil
+}
+
+// containerPressure returns the pressure stall information of the container's
+// cgroup. A container is only given a cgroup of its own when it has resources,
+// otherwise its process is in the runtime's cgroup, which isn't reported.
+func containerPressure(cntr *container.Container) (*anosys.PressureStats, error) {
+	if cntr.Spec.Linux == nil || cntr.Spec.Linux.Resources == nil {
+		return nil, fmt.Errorf(
+			"no container cgroup (%s): linux.resources not set",
+			cntr.State.ID,
+		)
+	}
+
+	return anosys.V2CGroupPressure(cntr.State.Pid)
+}
@@ -0,0 +1,131 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// RecvPty receives a pty master sent over conn with SendPty.
+func RecvPty(conn *net.UnixConn) (*os.File, error) {
+	buf := make([]byte, 8)
+	oob := make([]byte, unix.CmsgSpace(4))
+
+	_, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, fmt.Errorf("terminal recvmsg: %w", err)
+	}
+
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, fmt.Errorf("parse control message: %w", err)
+	}
+
+	if len(msgs) != 1 {
+		return nil, fmt.Errorf("expected 1 control message, got %d", len(msgs))
+	}
+
+	fds, err := unix.ParseUnixRights(&msgs[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse unix rights: %w", err)
+	}
+
+	if len(fds) != 1 {
+		for _, fd := range fds {
+			unix.Close(fd)
+		}
+		return nil, fmt.Errorf("expected 1 fd, got %d", len(fds))
+	}
+
+	unix.CloseOnExec(fds[0])
+
+	return os.NewFile(uintptr(fds[0]), "pty-master"), nil
+}
+
+// MakeRaw puts the terminal at fd in raw mode, so input is passed through
+// as is, e.g. ^C to the container rather than this process, and returns a
+// func to restore its previous state.
+func MakeRaw(fd int) (func() error, error) {
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, fmt.Errorf("get termios: %w", err)
+	}
+
+	prev := *termios
+
+	// as cfmakeraw(3)
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP |
+		unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG |
+		unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, termios); err != nil {
+		return nil, fmt.Errorf("set termios: %w", err)
+	}
+
+	return func() error {
+		return unix.IoctlSetTermios(fd, unix.TCSETS, &prev)
+	}, nil
+}
+
+func IsTerminal(fd int) bool {
+	_, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	return err == nil
+}
+
+// ForwardResize sets the size of master to that of the terminal at fd, now
+// and whenever it changes, until the returned func is called.
+func ForwardResize(fd int, master *os.File) func() {
+	resize := func() {
+		ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+		if err != nil {
+			return
+		}
+		unix.IoctlSetWinsize(int(master.Fd()), unix.TIOCSWINSZ, ws)
+	}
+
+	resize()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGWINCH)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-sigs:
+				resize()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
+// CopyFromPty copies output from master to dst until the container closes
+// its side of the pty.
+func CopyFromPty(dst io.Writer, master *os.File) error {
+	_, err := io.Copy(dst, master)
+	// reads fail with EIO, rather than EOF, once all slaves are closed
+	if errors.Is(err, unix.EIO) {
+		return nil
+	}
+
+	return err
+}
@@ -2,12 +2,17 @@ package terminal
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"unsafe"
 
-	"github.com/google/goterm/term"
+	"github.com/nixpig/anocir/internal/anosys"
 	"golang.org/x/sys/unix"
 )
 
@@ -16,18 +21,71 @@ type Pty struct {
 	Slave  *os.File
 }
 
-func NewPty() (*Pty, error) {
-	pty, err := term.OpenPTY()
+// NewPty allocates a pty from the devpts instance mounted in rootfs, so the
+// slave belongs to the container rather than the host.
+func NewPty(rootfs string) (*Pty, error) {
+	master, err := anosys.OpenInRoot(
+		rootfs,
+		"/dev/pts/ptmx",
+		unix.O_RDWR|unix.O_NOCTTY,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("open ptmx: %w", err)
+	}
+
+	if err := unix.IoctlSetPointerInt(
+		int(master.Fd()),
+		unix.TIOCSPTLCK,
+		0,
+	); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("unlock pty: %w", err)
+	}
+
+	n, err := unix.IoctlGetUint32(int(master.Fd()), unix.TIOCGPTN)
 	if err != nil {
-		return nil, fmt.Errorf("open pty: %w", err)
+		master.Close()
+		return nil, fmt.Errorf("get pty number: %w", err)
+	}
+
+	slavePath := fmt.Sprintf("/dev/pts/%d", n)
+
+	slave, err := openPtyPeer(master, slavePath)
+	if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOTTY) {
+		// TIOCGPTPEER isn't available (< 4.13), so open by path instead
+		slave, err = anosys.OpenInRoot(
+			rootfs,
+			slavePath,
+			unix.O_RDWR|unix.O_NOCTTY,
+		)
+	}
+	if err != nil {
+		master.Close()
+		return nil, fmt.Errorf("open pty slave: %w", err)
 	}
 
 	return &Pty{
-		Master: pty.Master,
-		Slave:  pty.Slave,
+		Master: master,
+		Slave:  slave,
 	}, nil
 }
 
+// openPtyPeer opens the slave of master without a path lookup, so it can't
+// be swapped for a different device.
+func openPtyPeer(master *os.File, name string) (*os.File, error) {
+	fd, _, errno := unix.Syscall(
+		unix.SYS_IOCTL,
+		master.Fd(),
+		unix.TIOCGPTPEER,
+		uintptr(unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC),
+	)
+	if errno != 0 {
+		return nil, errno
+	}
+
+	return os.NewFile(fd, name), nil
+}
+
 func (p *Pty) Connect() error {
 	if _, err := unix.Setsid(); err != nil {
 		return fmt.Errorf("setsid: %w", err)
@@ -52,67 +110,56 @@ func (p *Pty) Connect() error {
 	return nil
 }
 
-func (p *Pty) MountSlave(target string) error {
-	if _, err := os.Stat(target); os.IsNotExist(err) {
-		f, err := os.Create(target)
-		if err != nil && !os.IsExist(err) {
-			return fmt.Errorf("create device target if not exists: %w", err)
-		}
-		if f != nil {
-			f.Close()
-		}
+func (p *Pty) MountSlave(rootfs, dest string) error {
+	target, err := anosys.CreateInRoot(rootfs, dest, 0666)
+	if err != nil {
+		return fmt.Errorf("create device target if not exists: %w", err)
 	}
+	defer target.Close()
 
+	// bind via the fd, since the slave's path is only valid in the container
 	if err := syscall.Mount(
-		p.Slave.Name(),
-		target,
+		anosys.ProcFdPath(p.Slave),
+		anosys.ProcFdPath(target),
 		"bind",
 		syscall.MS_BIND,
 		"",
 	); err != nil {
-		return fmt.Errorf("mount pty slave device (%s) to target (%s): %w", p.Slave.Name(), target, err)
+		return fmt.Errorf("mount pty slave device (%s) to target (%s): %w", p.Slave.Name(), dest, err)
 	}
 
 	return nil
 }
 
-type PtySocket struct {
-	SocketFd int
-}
-
-func NewPtySocket(consoleSocketPath string) (*PtySocket, error) {
-	fd, err := syscall.Socket(
-		unix.AF_UNIX,
-		unix.SOCK_STREAM,
-		0,
-	)
+// ConnectConsoleSocket connects to the console socket at path, which the
+// pty master is sent over.
+func ConnectConsoleSocket(path string) (*os.File, error) {
+	// connect relative to an fd for the socket's dir, so long paths don't
+	// exceed the limit of sun_path
+	dir, err := os.OpenFile(filepath.Dir(path), unix.O_PATH|unix.O_DIRECTORY, 0)
 	if err != nil {
-		return nil, fmt.Errorf("create console socket: %w", err)
+		return nil, fmt.Errorf("open console socket dir: %w", err)
 	}
+	defer dir.Close()
 
-	if err := syscall.Connect(
-		fd,
-		&syscall.SockaddrUnix{
-			Name: consoleSocketPath,
-		},
-	); err != nil {
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{
+		Name: filepath.Join(anosys.ProcFdPath(dir), filepath.Base(path)),
+		Net:  "unix",
+	})
+	if err != nil {
 		return nil, fmt.Errorf("connect to console socket: %w", err)
 	}
+	defer conn.Close()
 
-	return &PtySocket{
-		SocketFd: fd,
-	}, nil
-}
-
-func (ps *PtySocket) Close() error {
-	if err := syscall.Close(ps.SocketFd); err != nil {
-		return fmt.Errorf("close console socket: %w", err)
+	f, err := conn.File()
+	if err != nil {
+		return nil, fmt.Errorf("get console socket file: %w", err)
 	}
 
-	return nil
+	return f, nil
 }
 
-func SendPty(consoleSocket int, pty *Pty) error {
+func SendPty(consoleSocket *os.File, pty *Pty) error {
 	masterFds := []int{int(pty.Master.Fd())}
 	cmsg := syscall.UnixRights(masterFds...)
 	size := unsafe.Sizeof(pty.Master.Fd())
@@ -128,7 +175,7 @@ func SendPty(consoleSocket int, pty *Pty) error {
 	}
 
 	if err := syscall.Sendmsg(
-		consoleSocket,
+		int(consoleSocket.Fd()),
 		buf,
 		cmsg,
 		nil,
@@ -140,30 +187,77 @@ func SendPty(consoleSocket int, pty *Pty) error {
 	return nil
 }
 
-func Setup(rootfs, consoleSocketPath string) (*int, error) {
-	prev, err := os.Getwd()
+// ControllingTerminal returns the path, through one of the fds of pid, to its
+// controlling terminal. The terminal's own path is only valid in its devpts
+// instance, and pid's stdin could have been redirected.
+func ControllingTerminal(pid int) (string, error) {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
 	if err != nil {
-		return nil, fmt.Errorf("get cwd: %w", err)
+		return "", fmt.Errorf("read process stat: %w", err)
+	}
+
+	// fields after the command, which could contain spaces
+	_, after, _ := strings.Cut(string(b), ") ")
+	fields := strings.Fields(after)
+	if len(fields) < 5 {
+		return "", fmt.Errorf("parse process stat: %s", b)
 	}
 
-	if err := os.Chdir(rootfs); err != nil {
-		return nil, fmt.Errorf("change to container root dir: %w", err)
+	ttyNr, err := strconv.ParseUint(fields[4], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("parse process tty: %w", err)
 	}
 
-	if err := os.Symlink(consoleSocketPath, "./console-socket"); err != nil {
-		return nil, fmt.Errorf("symlink console socket: %w", err)
+	if ttyNr == 0 {
+		return "", errors.New("process has no controlling terminal")
 	}
 
-	consoleSocket, err := NewPtySocket(
-		"./console-socket",
-	)
+	fdDir := fmt.Sprintf("/proc/%d/fd", pid)
+
+	entries, err := os.ReadDir(fdDir)
 	if err != nil {
-		return nil, fmt.Errorf("create terminal socket: %w", err)
+		return "", fmt.Errorf("read process fds: %w", err)
 	}
 
-	if err := os.Chdir(prev); err != nil {
-		return nil, fmt.Errorf("change back to previos cwd: %w", err)
+	for _, e := range entries {
+		path := filepath.Join(fdDir, e.Name())
+
+		var st unix.Stat_t
+		if err := unix.Stat(path, &st); err != nil {
+			continue
+		}
+
+		if st.Mode&unix.S_IFMT == unix.S_IFCHR &&
+			unix.Major(st.Rdev) == unix.Major(ttyNr) &&
+			unix.Minor(st.Rdev) == unix.Minor(ttyNr) {
+			return path, nil
+		}
 	}
 
-	return &consoleSocket.SocketFd, nil
+	return "", errors.New("process doesn't have its controlling terminal open")
+}
+
+// Resize sets the window size of the terminal at path, e.g. from
+// ControllingTerminal. The size is shared by both sides of a pty, so setting it on
+// the slave also notifies the foreground process with SIGWINCH.
+func Resize(path string, rows, cols uint16) error {
+	fd, err := unix.Open(path, unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("open terminal: %w", err)
+	}
+	defer unix.Close(fd)
+
+	if _, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ); err != nil {
+		return fmt.Errorf("not a terminal (%s): %w", path, err)
+	}
+
+	if err := unix.IoctlSetWinsize(
+		fd,
+		unix.TIOCSWINSZ,
+		&unix.Winsize{Row: rows, Col: cols},
+	); err != nil {
+		return fmt.Errorf("set window size: %w", err)
+	}
+
+	return nil
 }
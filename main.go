@@ -7,11 +7,11 @@ import (
 	"os"
 
 	"github.com/nixpig/anocir/internal/cli"
-	"github.com/thediveo/gons"
+	"github.com/nixpig/anocir/internal/nsenter"
 )
 
 func main() {
-	if err := gons.Status(); err != nil {
+	if err := nsenter.Status(); err != nil {
 		os.Stderr.Write([]byte(fmt.Sprintf("failed to join namespaces: %s\n", err)))
 		os.Exit(1)
 	}